@@ -4,10 +4,54 @@ package textiler
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// renderCorpus converts inputs of HtmlTests and XhtmlTests with opts, each
+// after a line with its name
+func renderCorpus(t *testing.T, opts *Options) []byte {
+	var buf bytes.Buffer
+	for _, tests := range []struct {
+		name   string
+		inputs []string
+	}{{"HtmlTests", HtmlTests}, {"XhtmlTests", XhtmlTests}} {
+		for i := 0; i < len(tests.inputs); i += 2 {
+			fmt.Fprintf(&buf, "-- %s %d --\n%s\n\n", tests.name, i/2, convertBytes(t, tests.inputs[i], opts))
+		}
+	}
+	return buf.Bytes()
+}
+
+// checkGolden compares got with testdata/name, which is replaced by got with
+// the -update flag
+func checkGolden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, exp) {
+		expLines, gotLines := bytes.Split(exp, newline), bytes.Split(got, newline)
+		for i := range gotLines {
+			if i >= len(expLines) || !bytes.Equal(gotLines[i], expLines[i]) {
+				t.Fatalf("%s differs at line %d, run go test -update to update it\nGot:%q\n", path, i+1, gotLines[i])
+			}
+		}
+		t.Fatalf("%s has more lines, run go test -update to update it", path)
+	}
+}
+
 func textileToHtml(input string) string {
 	return string(ToHtml([]byte(input), false, false))
 }
@@ -16,6 +60,15 @@ func textileToXhtml(input string) string {
 	return string(ToXhtml([]byte(input), false, false))
 }
 
+// renderInline renders inline children of n
+func renderInline(n Node, flags int) []byte {
+	doc := &Document{}
+	doc.AppendChild(n)
+	html := RenderHtml(doc, flags)
+	html = bytes.TrimPrefix(html, []byte("\t<p>"))
+	return bytes.TrimSuffix(html, []byte("</p>"))
+}
+
 func TestUrlRef(t *testing.T) {
 	data := []string{
 		"[hobix]http://hobix.com", "hobix", "http://hobix.com",
@@ -58,6 +111,24 @@ func TestParseSpan(t *testing.T) {
 	}
 }
 
+func TestParseStartTag(t *testing.T) {
+	data := []string{
+		"<pre>foo</pre>", "pre", "foo</pre>",
+		"<pre>a space after the tag</pre>", "pre", "a space after the tag</pre>",
+		"<div class=\"a b\">x", "div", "x",
+		"<foo>bar", "", "",
+	}
+	for i := 0; i < len(data)/3; i++ {
+		rest, _, tag := parseStartTag([]byte(data[i*3]))
+		if expected := data[i*3+1]; !bytes.Equal(tag, []byte(expected)) {
+			t.Fatalf("\nExpected[%s]\nActual  [%s]", expected, string(tag))
+		}
+		if expected := data[i*3+2]; !bytes.Equal(rest, []byte(expected)) {
+			t.Fatalf("\nExpected[%s]\nActual  [%s]", expected, string(rest))
+		}
+	}
+}
+
 func TestIsHLine(t *testing.T) {
 	data := []string{
 		"h1. foo", "1", "foo",
@@ -141,9 +212,10 @@ func TestParseInline(t *testing.T) {
 	for i := 0; i < len(data)/2; i++ {
 		p := NewParser(0)
 		s := data[i*2]
-		p.parseInline([]byte(s))
+		para := &Paragraph{}
+		p.parseInline(&para.Container, []byte(s))
 		expected := []byte(data[i*2+1])
-		actual := p.out.Bytes()
+		actual := renderInline(para, 0)
 		if !bytes.Equal(expected, actual) {
 			ToHtml([]byte(s), false, true)
 			t.Fatalf("\nSrc:[%s]\nExp:[%s]\nGot:[%s]", s, string(expected), string(actual))
@@ -186,9 +258,10 @@ func TestOl(t *testing.T) {
 func TestHtml(t *testing.T) {
 	tests := []string{
 		"Regardless:\n* a server, which accepts\n\nh3. The server",
-		// TODO: should be:
-		// "\t<p>Regardless:\t<ul>\n\t\t<li>a server, which accepts</li></ul></p>\n\n\t<h3>The server</h3>",
-		"\t<p>Regardless:\t<ul>\n\t\t<li>a server, which accepts</li>\n\t</ul></p>\n\n\t<h3>The server</h3>",
+		"\t<p>Regardless:</p>\n\n\t<ul>\n\t\t<li>a server, which accepts</li>\n\t</ul>\n\n\t<h3>The server</h3>",
+
+		"text\n<div>x</div>\nmore",
+		"\t<p>text</p>\n\n<div>x</div>\n\n\t<p>more</p>",
 	}
 	n := len(tests) / 2
 	for i := 0; i < n; i++ {
@@ -206,14 +279,13 @@ func TestTextileXhtml(t *testing.T) {
 	// 4,5,6,7,8,9,10 - smartypants for '"'
 	passingTests := []int{0, 1, 2, 3, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
 		21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38,
-		39, 40, 41, 42, 43, 45, 46, 48, 50, 51, 52, 53, 54, 62, 64, 66, 68, 69,
-		70, 74, 75, 76, 78, 79}
-	// 44, 47, 65, 94 - lists
+		39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 50, 51, 52, 53, 54, 55, 56, 57,
+		58, 61, 62, 64, 65, 66, 68, 69, 70, 72, 74, 75, 76, 78, 79, 83, 92, 98,
+		100}
+	// 94 - lists
 	// 49 - "foo (title)":http://my.com - parsing (title) and serializing as title="" attribute
-	// 55, 83 - use CSS(Acronyms) - parsing acronyms in ()
-	// 56, 57, 58, 59, 88, 89, 95, 96, 97, 98 - tables
-	// 60, 72 - pre..
-	// 61 - <pre>foo</pre>
+	// 59, 88, 89, 95, 96, 97 - tables
+	// 60 - pre..
 	// 63 - "foo ==(bar)==":#foobar
 	// 67 - #{color:blue} one - style for lists
 	// 71 - *:(foo)foo bar baz* - <cite> within '*' (strong)
@@ -225,9 +297,7 @@ func TestTextileXhtml(t *testing.T) {
 	// 86 - <-- comments
 	// 87 - (c) => &#169;, (r) => #174;, (tm) => &#8482
 	// 90, 91, 99, 101 - dl, dt
-	// 92 - *(class) - class in *
 	// 93 - #_(first#list) foo - class/id for lists
-	// 100 - ###. comment
 	for _, i := range passingTests {
 		s := XhtmlTests[i*2]
 		actual := textileToXhtml(s)
@@ -238,3 +308,29 @@ func TestTextileXhtml(t *testing.T) {
 		}
 	}
 }
+
+func TestParserReuse(t *testing.T) {
+	p := NewParser(0)
+	first := string(p.ToHtml([]byte("\"a\":x\n\n[x]http://x.com\n\n<pre>\nunclosed")))
+	if exp := "\t<p><a href=\"http://x.com\">a</a></p>\n\n<pre>\nunclosed"; first != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, first)
+	}
+	// references and html blocks of the first document don't leak into
+	// the second one
+	s := "\"b\":x\n\n* c"
+	if got, exp := string(p.ToHtml([]byte(s))), textileToHtml(s); got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+	p.Reset()
+	if p.doc != nil || len(p.refs) != 0 {
+		t.Fatalf("Reset didn't clear the parser")
+	}
+}
+
+func BenchmarkConvertSnippet(b *testing.B) {
+	s := []byte("h2. Snippet\n\nSome *strong* and _emphasized_ text with a \"link\":http://example.com.")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertBytes(s, nil)
+	}
+}
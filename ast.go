@@ -0,0 +1,309 @@
+package textiler
+
+import (
+	"fmt"
+)
+
+// Node is an element of a parsed textile document. Block nodes (Paragraph,
+// Heading, List, ...) are children of a Document, inline nodes (Text, Link,
+// Phrase, ...) are children of blocks.
+type Node interface {
+	// Children returns child nodes in document order
+	Children() []Node
+	// Position returns where the node starts in the source
+	Position() Pos
+}
+
+// Pos is a position in the source of a document. Line and Col start at 1,
+// Col counts bytes, like in go/token. The zero Pos is not a valid position,
+// e.g. of nodes that were not parsed from a source.
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+// IsValid returns true if p is a position in the source
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Attributes are the optional class, id, style and language of an element,
+// as given by e.g. p(class#id){color:red}[fr]. Short-hand alignment and
+// padding (p>., p((.) are folded into Style.
+type Attributes struct {
+	Class string
+	ID    string
+	Style string
+	Lang  string
+}
+
+// IsEmpty returns true if no attribute is set
+func (a Attributes) IsEmpty() bool {
+	return a.Class == "" && a.ID == "" && a.Style == "" && a.Lang == ""
+}
+
+// Container is embedded by nodes that have children
+type Container struct {
+	Nodes []Node
+	Pos   Pos
+}
+
+func (c *Container) Children() []Node {
+	return c.Nodes
+}
+
+func (c *Container) Position() Pos {
+	return c.Pos
+}
+
+func (c *Container) setPos(pos Pos) {
+	c.Pos = pos
+}
+
+// AppendChild adds n as the last child
+func (c *Container) AppendChild(n Node) {
+	c.Nodes = append(c.Nodes, n)
+}
+
+func (c *Container) lastChild() Node {
+	if len(c.Nodes) == 0 {
+		return nil
+	}
+	return c.Nodes[len(c.Nodes)-1]
+}
+
+// Leaf is embedded by nodes that don't have children
+type Leaf struct {
+	Pos Pos
+}
+
+func (l *Leaf) Children() []Node {
+	return nil
+}
+
+func (l *Leaf) Position() Pos {
+	return l.Pos
+}
+
+func (l *Leaf) setPos(pos Pos) {
+	l.Pos = pos
+}
+
+// Document is the root of a parsed document
+type Document struct {
+	Container
+	// Refs maps names of link references ([name]url lines) to urls
+	Refs map[string]string
+	// Diagnostics are problems found in the source, ordered by position
+	Diagnostics []Diagnostic
+	// Meta are the key: value pairs of the front matter, see
+	// Options.FrontMatter, nil if there's none
+	Meta map[string]string
+}
+
+// Paragraph is a p. block or a block of text without a signature
+type Paragraph struct {
+	Container
+	Attrs Attributes
+}
+
+// Heading is a h1. to h6. block
+type Heading struct {
+	Container
+	Level int
+	Attrs Attributes
+}
+
+// BlockQuote is a bq. block. Its children are paragraphs.
+type BlockQuote struct {
+	Container
+	Attrs Attributes
+	// Cite is the url of the source of the quote, from bq.:url
+	Cite string
+}
+
+// List is a list of # (ordered) or * (unordered) items. Its children are
+// list items.
+type List struct {
+	Container
+	Ordered bool
+	Attrs   Attributes
+}
+
+// ListItem is an item of a List. Nested lists are children of the item they
+// follow.
+type ListItem struct {
+	Container
+}
+
+// CodeBlock is a bc. or pre. block
+type CodeBlock struct {
+	Leaf
+	Text  string
+	Attrs Attributes
+	// Preformatted is true for pre. blocks, which are not marked as code
+	Preformatted bool
+}
+
+// HTMLBlock is a <pre> or <code> html block. Its children are the html tags
+// (RawHTML) and the text between them.
+type HTMLBlock struct {
+	Container
+}
+
+// Table is a table block. Its children are table rows.
+type Table struct {
+	Container
+	Attrs Attributes
+}
+
+// TableRow is a |cell|cell| line. Its children are table cells.
+type TableRow struct {
+	Container
+	Attrs Attributes
+}
+
+// TableCell is a cell of a TableRow
+type TableCell struct {
+	Container
+	Attrs   Attributes
+	Header  bool
+	Colspan int
+	Rowspan int
+}
+
+// Footnote is a fn1. block
+type Footnote struct {
+	Container
+	ID    string
+	Attrs Attributes
+}
+
+// Text is text without markup
+type Text struct {
+	Leaf
+	Text string
+}
+
+// LineBreak separates lines of a block
+type LineBreak struct {
+	Leaf
+}
+
+// Phrase is a text modifier like _emphasis_ or *strong*. Tag is the name of
+// the corresponding html element (em, strong, i, b, cite, del, ins, sup, sub).
+type Phrase struct {
+	Container
+	Tag   string
+	Attrs Attributes
+}
+
+// Span is a %span%
+type Span struct {
+	Container
+	Attrs Attributes
+}
+
+// Acronym is an ACRONYM(title)
+type Acronym struct {
+	Leaf
+	Text  string
+	Title string
+}
+
+// Code is an @inline code@
+type Code struct {
+	Leaf
+	Text string
+}
+
+// Link is a "text":url link or a [[Page]] wiki link. Its children are the
+// link text.
+type Link struct {
+	Container
+	URL string
+	// Ref is the reference name the url was looked up with, if any
+	Ref string
+	// Wiki is true for [[Page]] and [[Page|text]] links
+	Wiki bool
+	// Missing is true if the LinkResolver reported that the target
+	// doesn't exist
+	Missing bool
+
+	// was it passed to the LinkResolver?
+	resolved bool
+}
+
+// Image is a !src(alt)!:url image
+type Image struct {
+	Leaf
+	Src   string
+	Alt   string
+	Attrs Attributes
+	// URL is the target of the link the image is wrapped in, if any
+	URL string
+	// Width and Height are the size of the image in pixels, 0 if unknown
+	Width  int
+	Height int
+	// Loading is the loading attribute, e.g. "lazy"
+	Loading string
+}
+
+// RawHTML is html copied to the output verbatim, from notextile. blocks or
+// html tags in the text
+type RawHTML struct {
+	Leaf
+	HTML string
+}
+
+// FootnoteRef is a reference[1] to a footnote
+type FootnoteRef struct {
+	Leaf
+	ID string
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a document tree in depth-first order: it starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w for
+// each of the children of node, followed by a call of w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, c := range node.Children() {
+		Walk(v, c)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a document tree in depth-first order: it starts by
+// calling f(node); node must not be nil. If f returns true, Inspect invokes
+// f recursively for each of the children of node, followed by a call of
+// f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,62 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestParseTree(t *testing.T) {
+	doc := Parse([]byte("h2(#intro). Hello *world*\n\n# one\n## two\n\n|_. a |b|\n\nfn1. note"))
+	if len(doc.Nodes) != 4 {
+		t.Fatalf("expected 4 blocks, got %d", len(doc.Nodes))
+	}
+	h, ok := doc.Nodes[0].(*Heading)
+	if !ok || h.Level != 2 || h.Attrs.ID != "intro" {
+		t.Fatalf("unexpected heading %#v", doc.Nodes[0])
+	}
+	if ph, ok := h.Nodes[1].(*Phrase); !ok || ph.Tag != "strong" {
+		t.Fatalf("unexpected phrase %#v", h.Nodes[1])
+	}
+	list := doc.Nodes[1].(*List)
+	item := list.Nodes[0].(*ListItem)
+	if nested, ok := item.Nodes[1].(*List); !ok || !nested.Ordered {
+		t.Fatalf("expected nested list, got %#v", item.Nodes[1])
+	}
+	row := doc.Nodes[2].(*Table).Nodes[0].(*TableRow)
+	if cell := row.Nodes[0].(*TableCell); !cell.Header {
+		t.Fatalf("expected header cell")
+	}
+	if fn := doc.Nodes[3].(*Footnote); fn.ID != "1" {
+		t.Fatalf("unexpected footnote id %q", fn.ID)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	s := "\"a\":http://a.com and !b.png!:http://b.com\n\n* %\"c\":c%\n\n[c]http://c.com"
+	var urls []string
+	Inspect(Parse([]byte(s)), func(n Node) bool {
+		switch n := n.(type) {
+		case *Link:
+			urls = append(urls, n.URL)
+		case *Image:
+			urls = append(urls, n.Src, n.URL)
+		}
+		return true
+	})
+	exp := []string{"http://a.com", "b.png", "http://b.com", "http://c.com"}
+	if len(urls) != len(exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, urls)
+	}
+	for i := range exp {
+		if urls[i] != exp[i] {
+			t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, urls)
+		}
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	s := "See this[1] and that[1].\n\nfn1. The note."
+	exp := "\t<p>See this<sup class=\"footnote\" id=\"fnrev1\"><a href=\"#fn1\">1</a></sup> and that<sup class=\"footnote\"><a href=\"#fn1\">1</a></sup>.</p>\n\n\t<p class=\"footnote\" id=\"fn1\"><sup>1</sup> The note.</p>"
+	if got := textileToHtml(s); got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
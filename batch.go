@@ -0,0 +1,88 @@
+package textiler
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Input is a named document for ConvertBatch
+type Input struct {
+	// Name identifies the input in its Result, e.g. a file name
+	Name string
+	Data []byte
+}
+
+// Result is the result of converting an Input
+type Result struct {
+	Name   string
+	Output []byte
+	// Meta is the metadata of the input, see ConvertWithMeta
+	Meta        map[string]string
+	Diagnostics []Diagnostic
+	// Err is not nil if the input wasn't converted, e.g. because the
+	// context was cancelled first
+	Err error
+}
+
+// ConvertBatch converts inputs as configured by opts, like ConvertBytes,
+// on at most workers goroutines at a time (runtime.GOMAXPROCS(0) if
+// workers is 0 or less). Result i is the result of inputs[i].
+//
+// Documents share opts, so its hooks and Trace are called concurrently.
+// Use opts.Refs for references common to all documents.
+//
+// If ctx is cancelled, inputs that weren't started yet aren't converted
+// and get ctx.Err() as the error of their results, which ConvertBatch
+// also returns if there are any. The other error is that of invalid opts.
+func ConvertBatch(ctx context.Context, inputs []Input, workers int, opts *Options) ([]Result, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+	results := make([]Result, len(inputs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = convertInput(ctx, inputs[i], opts)
+			}
+		}()
+	}
+dispatch:
+	for i := range inputs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			for j := i; j < len(inputs); j++ {
+				results[j] = Result{Name: inputs[j].Name, Err: ctx.Err()}
+			}
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+	for _, res := range results {
+		if res.Err != nil && res.Err == ctx.Err() {
+			return results, res.Err
+		}
+	}
+	return results, nil
+}
+
+func convertInput(ctx context.Context, in Input, opts *Options) Result {
+	res := Result{Name: in.Name}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+	res.Output, res.Meta, res.Diagnostics, res.Err = ConvertWithMeta(in.Data, opts)
+	return res
+}
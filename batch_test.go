@@ -0,0 +1,94 @@
+package textiler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestConvertBatch(t *testing.T) {
+	var inputs []Input
+	for i := 0; i < len(HtmlTests)/2; i++ {
+		inputs = append(inputs, Input{Name: fmt.Sprint(i), Data: []byte(HtmlTests[i*2])})
+	}
+	inputs = append(inputs, Input{Name: "refs", Data: []byte("\"a\":home and \"b\":own\n\n[own]http://own.com")})
+	opts := &Options{Refs: map[string]string{"home": "http://home.com", "own": "http://global.com"}}
+	results, err := ConvertBatch(context.Background(), inputs, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("expected %d results, got %d", len(inputs), len(results))
+	}
+	for i, res := range results[:len(results)-1] {
+		if res.Name != inputs[i].Name || res.Err != nil {
+			t.Fatalf("unexpected result %d: %#v", i, res)
+		}
+		if exp := textileToHtml(string(inputs[i].Data)); string(res.Output) != exp {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", string(inputs[i].Data), exp, string(res.Output))
+		}
+	}
+	exp := "\t<p><a href=\"http://home.com\">a</a> and <a href=\"http://own.com\">b</a></p>"
+	if got := string(results[len(results)-1].Output); got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+	if diags := results[len(results)-1].Diagnostics; len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %v", diags)
+	}
+}
+
+func TestConvertBatchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inputs := []Input{{Name: "a", Data: []byte("a")}, {Name: "b", Data: []byte("b")}}
+	results, err := ConvertBatch(ctx, inputs, 0, nil)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	for i, res := range results {
+		if res.Name != inputs[i].Name || res.Err != context.Canceled || res.Output != nil {
+			t.Fatalf("unexpected result %d: %#v", i, res)
+		}
+	}
+	if _, err := ConvertBatch(context.Background(), inputs, 0, &Options{Flavor: -1}); err == nil {
+		t.Fatal("expected an error for invalid options")
+	}
+}
+
+// cancelOn returns options that cancel the batch while converting text
+func cancelOn(text string, cancel context.CancelFunc) *Options {
+	return &Options{Hooks: Hooks{Block: func(n Node) {
+		if p, ok := n.(*Paragraph); ok && p.Nodes[0].(*Text).Text == text {
+			cancel()
+		}
+	}}}
+}
+
+func TestConvertBatchCancelWhileConverting(t *testing.T) {
+	inputs := []Input{{Name: "a", Data: []byte("a")}, {Name: "b", Data: []byte("b")}}
+	// all inputs are started before the last one cancels
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := ConvertBatch(ctx, inputs, 1, cancelOn("b", cancel))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, res := range results {
+		if res.Name != inputs[i].Name || res.Err != nil || res.Output == nil {
+			t.Fatalf("unexpected result %d: %#v", i, res)
+		}
+	}
+	// inputs after the one that cancels aren't started
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	results, err = ConvertBatch(ctx, inputs, 1, cancelOn("a", cancel))
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if res := results[0]; res.Err != nil || res.Output == nil {
+		t.Fatalf("unexpected result 0: %#v", res)
+	}
+	if res := results[1]; res.Name != "b" || res.Err != context.Canceled || res.Output != nil {
+		t.Fatalf("unexpected result 1: %#v", res)
+	}
+}
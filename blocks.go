@@ -0,0 +1,230 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CustomBlock is a block with a signature registered with
+// Options.RegisterBlock, as passed to its BlockFunc
+type CustomBlock struct {
+	// Name is the name of the signature, e.g. "note" for note. blocks
+	Name  string
+	Attrs Attributes
+	// Extended is true for the name.. form, which lasts until the next
+	// block signature rather than until an empty line
+	Extended bool
+	// Content is the text after the signature, with lines separated by \n
+	Content string
+	Pos     Pos
+
+	p *TextileParser
+	// positions of the lines of Content in the document
+	lines []Pos
+}
+
+// BlockFunc converts a custom block to nodes that take its place in the
+// document, e.g. a *Paragraph with the result of ParseContent or a
+// *RawHTML with html. If it returns an error, it's reported as a
+// diagnostic and the content is rendered as a paragraph of text.
+type BlockFunc func(b *CustomBlock) ([]Node, error)
+
+// builtinBlocks are the names of built-in block signatures
+var builtinBlocks = map[string]bool{
+	"p":         true,
+	"bq":        true,
+	"bc":        true,
+	"pre":       true,
+	"notextile": true,
+	"table":     true,
+}
+
+// isBlockName returns true if name can be the name of a signature: a
+// lower-case letter followed by lower-case letters and digits
+func isBlockName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || i > 0 && isDigit(c)) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// isBuiltinBlock returns true if name is the name of a built-in signature
+func isBuiltinBlock(name string) bool {
+	if builtinBlocks[name] {
+		return true
+	}
+	if len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6' {
+		return true
+	}
+	if strings.HasPrefix(name, "fn") && len(name) > 2 {
+		_, n := parseNumber([]byte(name[2:]))
+		return n > 0
+	}
+	return false
+}
+
+// $name($classOpt){$styleOpt}[$langOpt]. $rest or the same with ..
+func parseSignature(l []byte) (rest, name []byte, attrs *AttributesOpt, extended bool) {
+	i := 0
+	for i < len(l) && (l[i] >= 'a' && l[i] <= 'z' || i > 0 && isDigit(l[i])) {
+		i += 1
+	}
+	if i == 0 {
+		return nil, nil, nil, false
+	}
+	name = l[:i]
+	rest, attrs = parseAttributesOpt(l[i:], false)
+	if !startsWithByte(rest, '.', 1) {
+		return nil, nil, nil, false
+	}
+	rest = rest[1:]
+	if startsWithByte(rest, '.', 1) {
+		rest = rest[1:]
+		extended = true
+	}
+	if len(rest) > 0 {
+		if rest[0] != ' ' {
+			return nil, nil, nil, false
+		}
+		rest = rest[1:]
+	}
+	return rest, name, attrs, extended
+}
+
+// isSignature returns true if l starts with a built-in or custom block
+// signature
+func (p *TextileParser) isSignature(l []byte) bool {
+	if parseComment(l) != nil {
+		return true
+	}
+	_, name, _, _ := parseSignature(l)
+	return name != nil && (isBuiltinBlock(string(name)) || p.blocks[string(name)] != nil)
+}
+
+// startCustomBlock starts a custom block if l starts with a registered
+// signature. Custom signatures take precedence over built-in ones with the
+// same name.
+func (p *TextileParser) startCustomBlock(l []byte) bool {
+	if len(p.blocks) == 0 {
+		return false
+	}
+	rest, name, attrs, extended := parseSignature(l)
+	if name == nil || p.blocks[string(name)] == nil {
+		return false
+	}
+	p.closeBlock()
+	p.custom = &CustomBlock{
+		Name:     string(name),
+		Attrs:    attrs.attributes(),
+		Extended: extended,
+		Pos:      p.linePos(),
+		p:        p,
+		lines:    []Pos{p.posAfter(l[:len(l)-len(rest)])},
+	}
+	p.literal.Write(rest)
+	return true
+}
+
+// addCustomLine adds l to the content of the custom block being built and
+// returns true, or returns false if l ends the block
+func (p *TextileParser) addCustomLine(l []byte) bool {
+	if p.customBlank {
+		p.customBlank = false
+		if p.isSignature(l) {
+			p.closeBlock()
+			return false
+		}
+		p.literal.Write(newline)
+		// an empty line has no position of its own after firstPass
+		p.custom.lines = append(p.custom.lines, p.linePos())
+	}
+	p.literal.Write(newline)
+	p.literal.Write(l)
+	p.custom.lines = append(p.custom.lines, p.linePos())
+	return true
+}
+
+// endCustomBlock converts the custom block being built with its BlockFunc
+func (p *TextileParser) endCustomBlock() {
+	b := p.custom
+	b.Content = string(bytes.TrimRight(p.literal.Bytes(), "\n"))
+	nodes, err := p.blocks[b.Name](b)
+	if err != nil {
+		p.report(b.Pos, SeverityError, DiagBlockFailed, "%s. block: %s", b.Name, err)
+		para := &Paragraph{}
+		para.Pos = b.Pos
+		para.AppendChild(&Text{Leaf: Leaf{Pos: b.Pos}, Text: b.Content})
+		nodes = []Node{para}
+	}
+	for _, n := range nodes {
+		p.addBlock(n)
+	}
+}
+
+// docPos returns the position in the document of pos in b.Content
+func (b *CustomBlock) docPos(pos Pos) Pos {
+	if !pos.IsValid() {
+		return pos
+	}
+	if pos.Line > len(b.lines) {
+		return b.Pos
+	}
+	start := b.lines[pos.Line-1]
+	return Pos{Offset: start.Offset + pos.Col - 1, Line: start.Line, Col: start.Col + pos.Col - 1}
+}
+
+// ParseContent parses the content of b as textile, with the options of the
+// document b is in, and returns the blocks. Diagnostics found in the
+// content are added to those of the document.
+func (b *CustomBlock) ParseContent() []Node {
+	p := b.p
+	child := NewParser(p.flags)
+	child.limits = p.limits
+	child.restricted = p.restricted
+	child.blocks = p.blocks
+	child.setPhrases(p.phrases)
+	child.wikiLinks = p.wikiLinks
+	// references of the document are global ones for the content
+	refs := make(map[string]string, len(p.globalRefs)+len(p.refs))
+	for name, url := range p.globalRefs {
+		refs[name] = url
+	}
+	for name, ref := range p.refs {
+		refs[name] = string(ref.url)
+	}
+	child.globalRefs = refs
+	child.parentChecksRefs = true
+	doc := child.parse([]byte(b.Content))
+	for _, n := range doc.Nodes {
+		Inspect(n, func(n Node) bool {
+			if m, ok := n.(interface{ setPos(Pos) }); ok {
+				m.setPos(b.docPos(n.Position()))
+			}
+			return n != nil
+		})
+	}
+	for _, d := range doc.Diagnostics {
+		d.Pos = b.docPos(d.Pos)
+		p.diags = append(p.diags, d)
+	}
+	return doc.Nodes
+}
+
+// RegisterBlock registers fn as the converter of blocks with the
+// signature name. (e.g. "note" for note. and note.. blocks), which may
+// have attributes like built-in blocks, e.g. note(warning). If name is
+// also the name of a built-in signature (like "bq"), fn replaces it.
+func (opts *Options) RegisterBlock(name string, fn BlockFunc) error {
+	if !isBlockName(name) {
+		return fmt.Errorf("textiler: invalid block name %q", name)
+	}
+	if opts.Blocks == nil {
+		opts.Blocks = make(map[string]BlockFunc)
+	}
+	opts.Blocks[name] = fn
+	return nil
+}
@@ -0,0 +1,116 @@
+package textiler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func noteBlock(b *CustomBlock) ([]Node, error) {
+	class := "note"
+	if b.Attrs.Class != "" {
+		class += " " + b.Attrs.Class
+	}
+	nodes := []Node{&RawHTML{HTML: fmt.Sprintf(`<div class="%s">`, class)}}
+	nodes = append(nodes, b.ParseContent()...)
+	return append(nodes, &RawHTML{HTML: "</div>"}), nil
+}
+
+func TestCustomBlocks(t *testing.T) {
+	opts := &Options{}
+	opts.RegisterBlock("note", noteBlock)
+	opts.RegisterBlock("bq", func(b *CustomBlock) ([]Node, error) {
+		return []Node{&RawHTML{HTML: "<aside>" + b.Content + "</aside>"}}, nil
+	})
+	opts.RegisterBlock("toc", func(b *CustomBlock) ([]Node, error) {
+		return nil, errors.New("no headings")
+	})
+	tests := []string{
+		"note(tip). *Hi*\n\"there\":x\n\n[x]http://x.com",
+		"<div class=\"note tip\">\n\n\t<p><strong>Hi</strong><br>\n<a href=\"http://x.com\">there</a></p>\n\n</div>",
+
+		"note.. one\n\ntwo\n\np. three",
+		"<div class=\"note\">\n\n\t<p>one</p>\n\n\t<p>two</p>\n\n</div>\n\n\t<p>three</p>",
+
+		"note.. one\n\nbq. two",
+		"<div class=\"note\">\n\n\t<p>one</p>\n\n</div>\n\n<aside>two</aside>",
+
+		// not a signature: unknown name or no space after the dot
+		"nope. a\n\nnote.b",
+		"\t<p>nope. a</p>\n\n\t<p>note.b</p>",
+
+		"toc.",
+		"\t<p></p>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		s := tests[i]
+		got, diags, err := ConvertBytes([]byte(s), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, tests[i+1], string(got))
+		}
+		if streamed, _ := convertString(s, opts); streamed != string(got) {
+			t.Fatalf("\nSrc:%#v\n\nConvert:%#v\n\nConvertBytes:%#v\n", s, streamed, string(got))
+		}
+		if s == "toc." && (len(diags) != 1 || diags[0].Code != DiagBlockFailed) {
+			t.Fatalf("expected a %s diagnostic, got %v", DiagBlockFailed, diags)
+		}
+	}
+}
+
+func TestRegisterBlockName(t *testing.T) {
+	opts := &Options{}
+	for _, name := range []string{"", "Note", "1st", "no-te"} {
+		if err := opts.RegisterBlock(name, noteBlock); err == nil {
+			t.Fatalf("expected an error for %q", name)
+		}
+	}
+	opts.Blocks = map[string]BlockFunc{"a b": noteBlock}
+	if _, _, err := ConvertBytes([]byte("a"), opts); err == nil {
+		t.Fatal("expected an error for an invalid name in Blocks")
+	}
+}
+
+func TestCustomBlockDiagnostics(t *testing.T) {
+	var blocks []Node
+	opts := &Options{Hooks: Hooks{Block: func(n Node) { blocks = append(blocks, n) }}}
+	opts.RegisterBlock("note", noteBlock)
+	s := "Hi\n\nnote.. a <b>x\n\nb \"l\":fo\n\n[foo]http://x.com"
+	exp := []Diagnostic{
+		{Pos{Offset: 13, Line: 3, Col: 10}, SeverityWarning, DiagUnclosedTag, "<b> is not closed"},
+		{Pos{Offset: 21, Line: 5, Col: 3}, SeverityWarning, DiagUndefinedRef, `reference "fo" is not defined, did you mean "foo"?`},
+	}
+	_, diags, err := ConvertBytes([]byte(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diags, exp) {
+		t.Fatalf("\nExp:%v\n\nGot:%v\n", exp, diags)
+	}
+	var buf bytes.Buffer
+	if diags, err = Convert(&buf, strings.NewReader(s), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diags, exp) {
+		t.Fatalf("Convert:\nExp:%v\n\nGot:%v\n", exp, diags)
+	}
+	// the paragraphs of the content are at their positions in the document
+	var got []Pos
+	for _, n := range blocks {
+		Inspect(n, func(n Node) bool {
+			if p, ok := n.(*Paragraph); ok {
+				got = append(got, p.Pos)
+			}
+			return n != nil
+		})
+	}
+	expPos := []Pos{{0, 1, 1}, {11, 3, 8}, {19, 5, 1}}
+	if len(got) < 3 || !reflect.DeepEqual(got[:3], expPos) {
+		t.Fatalf("\nExp:%v\n\nGot:%v\n", expPos, got)
+	}
+}
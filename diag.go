@@ -0,0 +1,199 @@
+package textiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity tells how serious a Diagnostic is
+type Severity int
+
+const (
+	// SeverityWarning is for markup that is likely a mistake, e.g. an
+	// unclosed %span, which is rendered as text
+	SeverityWarning Severity = iota
+	// SeverityError is for input that isn't converted as written, e.g.
+	// because it exceeds Limits
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Codes of diagnostics. They don't change between versions, so they can be
+// used to filter or look up diagnostics.
+const (
+	// a % starts a span that isn't closed on the same line
+	DiagUnclosedSpan = "unclosed-span"
+	// a link target isn't a defined reference, but one with a similar
+	// name is
+	DiagUndefinedRef = "undefined-ref"
+	// an html end tag doesn't match the last open tag
+	DiagUnmatchedTag = "unmatched-tag"
+	// an html start tag isn't closed before its parent or the end of the
+	// document
+	DiagUnclosedTag = "unclosed-tag"
+	// the input is larger than Limits.MaxInputSize, the rest of it is
+	// rendered as text
+	DiagInputTooLarge = "input-too-large"
+	// inline markup is nested deeper than Limits.MaxNestingDepth
+	DiagNestingTooDeep = "nesting-too-deep"
+	// a line needs more work than Limits.MaxLineWork, the rest of it is
+	// rendered as text
+	DiagLineTooComplex = "line-too-complex"
+	// a line is longer than Limits.MaxLineSize, it and the rest of the
+	// input are rendered as text
+	DiagLineTooLong = "line-too-long"
+	// the BlockFunc of a custom block returned an error
+	DiagBlockFailed = "block-failed"
+	// the LinkResolver reported that the target of a link doesn't exist
+	DiagMissingLink = "missing-link"
+	// a local image doesn't exist in Options.ImageRoot
+	DiagMissingImage = "missing-image"
+	// the size of a local image can't be read, e.g. because of an unknown
+	// format
+	DiagBadImage = "bad-image"
+)
+
+// Diagnostic is a problem found in the source of a document
+type Diagnostic struct {
+	Pos      Pos
+	Severity Severity
+	Code     string
+	Message  string
+}
+
+// String returns the diagnostic as "line:col: severity: message (code)"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", d.Pos, d.Severity, d.Message, d.Code)
+}
+
+func (p *TextileParser) report(pos Pos, severity Severity, code, format string, args ...interface{}) {
+	p.diags = append(p.diags, Diagnostic{
+		Pos:      pos,
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// reportLimit reports that a limit was hit, at most once per line
+func (p *TextileParser) reportLimit(pos Pos, code, format string, args ...interface{}) {
+	if p.limitLine == p.lineNo && p.limitCode == code {
+		return
+	}
+	p.limitLine = p.lineNo
+	p.limitCode = code
+	p.report(pos, SeverityError, code, format, args...)
+}
+
+// openTag is an html start tag that wasn't closed yet
+type openTag struct {
+	name string
+	pos  Pos
+}
+
+// checkTag matches html end tags with start tags
+func (p *TextileParser) checkTag(tag string, start bool, pos Pos) {
+	if start {
+		p.openTags = append(p.openTags, openTag{tag, pos})
+		return
+	}
+	for i := len(p.openTags) - 1; i >= 0; i-- {
+		if p.openTags[i].name != tag {
+			continue
+		}
+		for _, t := range p.openTags[i+1:] {
+			p.report(t.pos, SeverityWarning, DiagUnclosedTag, "<%s> is not closed before </%s>", t.name, tag)
+		}
+		p.openTags = p.openTags[:i]
+		return
+	}
+	p.report(pos, SeverityWarning, DiagUnmatchedTag, "</%s> without a matching <%s>", tag, tag)
+}
+
+// checkRefs reports links in n with targets that aren't defined references
+// but are similar to the name of one. Other bare words are taken to be
+// relative urls, e.g. "About":about.
+func (p *TextileParser) checkRefs(n Node) {
+	Inspect(n, func(n Node) bool {
+		if l, ok := n.(*Link); ok && l.Ref == "" && !l.Wiki && isRefName(l.URL) {
+			if _, ok := p.lookupRef(l.URL); !ok {
+				if similar := p.similarRef(l.URL); similar != "" {
+					p.report(l.Pos, SeverityWarning, DiagUndefinedRef, "reference %q is not defined, did you mean %q?", l.URL, similar)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// maxSimilarRefName is the length of the longest names of references that
+// are matched with names that have a typo in them
+const maxSimilarRefName = 64
+
+// refKeys returns the keys of a reference name for finding similar names:
+// the name in lower case and, unless it's very short or long, the name
+// without each of its bytes. Names that share a key differ in case or by
+// a typo or two.
+func refKeys(name string) []string {
+	name = strings.ToLower(name)
+	keys := []string{name}
+	if len(name) < 3 || len(name) > maxSimilarRefName {
+		return keys
+	}
+	for i := 0; i < len(name); i++ {
+		keys = append(keys, name[:i]+name[i+1:])
+	}
+	return keys
+}
+
+// indexRef adds the name of a reference to similarRefs. Names that share a
+// key are ordered so that the result of similarRef doesn't depend on the
+// order of definitions.
+func (p *TextileParser) indexRef(name string) {
+	if p.similarRefs == nil {
+		p.similarRefs = make(map[string]string)
+	}
+	for _, key := range refKeys(name) {
+		if old, ok := p.similarRefs[key]; !ok || name < old {
+			p.similarRefs[key] = name
+		}
+	}
+}
+
+// similarRef returns the name of a defined reference similar to name, ""
+// if there's none
+func (p *TextileParser) similarRef(name string) string {
+	if !p.globalIndexed {
+		for ref := range p.globalRefs {
+			p.indexRef(ref)
+		}
+		p.globalIndexed = true
+	}
+	similar := ""
+	for _, key := range refKeys(name) {
+		if ref, ok := p.similarRefs[key]; ok && (similar == "" || ref < similar) {
+			similar = ref
+		}
+	}
+	return similar
+}
+
+// endDiagnostics reports what's left open at the end of the document and
+// returns diagnostics ordered by position
+func (p *TextileParser) endDiagnostics() []Diagnostic {
+	for _, t := range p.openTags {
+		p.report(t.pos, SeverityWarning, DiagUnclosedTag, "<%s> is not closed", t.name)
+	}
+	p.openTags = p.openTags[:0]
+	sort.SliceStable(p.diags, func(i, j int) bool {
+		return p.diags[i].Pos.Offset < p.diags[j].Pos.Offset
+	})
+	return p.diags
+}
@@ -0,0 +1,91 @@
+package textiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	s := "h1. a *b*\r\n\r\nx \"l\":http://a.com\n* i"
+	doc := Parse([]byte(s))
+	var got []string
+	Inspect(doc, func(n Node) bool {
+		switch n.(type) {
+		case *Heading, *Phrase, *Paragraph, *Link, *List, *ListItem:
+			pos := n.Position()
+			got = append(got, strings.TrimPrefix(reflect.TypeOf(n).String(), "*textiler.")+" "+pos.String())
+			if s[pos.Offset] != "h*x\"**"[len(got)-1] {
+				t.Errorf("wrong offset %d of %s", pos.Offset, got[len(got)-1])
+			}
+		}
+		return true
+	})
+	exp := []string{"Heading 1:1", "Phrase 1:7", "Paragraph 3:1", "Link 3:3", "List 4:1", "ListItem 4:1"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func diagCodes(diags []Diagnostic) []string {
+	var res []string
+	for _, d := range diags {
+		res = append(res, d.Pos.String()+" "+d.Code)
+	}
+	return res
+}
+
+func TestDiagnostics(t *testing.T) {
+	s := "a %span\n\n[home]http://x.com\n\n\"b\":hom and \"c\":home\n\n<div>\n\n</p>"
+	exp := []string{"1:3 unclosed-span", "5:1 undefined-ref", "7:1 unclosed-tag", "9:1 unmatched-tag"}
+	_, diags, err := ConvertBytes([]byte(s), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diagCodes(diags); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+	if got := diags[1].String(); got != `5:1: warning: reference "hom" is not defined, did you mean "home"? (undefined-ref)` {
+		t.Fatalf("unexpected message %q", got)
+	}
+
+	// bare words unlike names of references are relative urls
+	for _, s := range []string{"\"About\":about", "[home]http://x.com\n\n\"About\":about \"a\":ho"} {
+		if _, diags, _ := ConvertBytes([]byte(s), nil); len(diags) != 0 {
+			t.Fatalf("unexpected diagnostics %v for %#v", diags, s)
+		}
+	}
+	_, diags, _ = ConvertBytes([]byte("\"a\":Home \"b\":hmoe"), &Options{Refs: map[string]string{"home": "http://x.com"}})
+	exp = []string{"1:1 undefined-ref", "1:10 undefined-ref"}
+	if got := diagCodes(diags); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+
+	_, diags, _ = ConvertBytes([]byte("abc\n__a__"), &Options{Limits: Limits{MaxInputSize: 2}})
+	exp = []string{"1:1 input-too-large"}
+	if got := diagCodes(diags); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestConvertDiagnostics(t *testing.T) {
+	inputs := []string{
+		"a %span\r\n\r\n\"b\":missing\r\n\r\n<div>\r\n\r\n</p>",
+		"\"a\":later\n\n[later]http://a.com",
+		"\"a\":latr\n\n[later]http://a.com",
+	}
+	for i := 0; i < len(HtmlTests)/2; i++ {
+		inputs = append(inputs, HtmlTests[i*2])
+	}
+	for _, s := range inputs {
+		_, exp, _ := ConvertBytes([]byte(s), nil)
+		var out strings.Builder
+		got, err := Convert(&out, strings.NewReader(s), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("\nSrc:%#v\n\nExp:%v\n\nGot:%v\n", s, exp, got)
+		}
+	}
+}
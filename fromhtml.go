@@ -0,0 +1,729 @@
+package textiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FromHtml converts an html fragment to textile. Markup that textile
+// can't express is kept as html: inline elements as tags in the text and
+// blocks as notextile. blocks. Converting the result back to html gives
+// the same elements, attributes and text, except for whitespace.
+func FromHtml(html []byte) []byte {
+	c := &htmlConverter{p: NewParser(0)}
+	blocks := c.blocks(parseHtmlFragment(string(html)).children)
+	return []byte(strings.Join(blocks, "\n\n"))
+}
+
+type htmlConverter struct {
+	// parses the textile of blocks to check it
+	p *TextileParser
+	// write phrases as html tags
+	rawPhrases bool
+}
+
+// elements converted as blocks, in addition to flowTags
+var blockLevelTags = map[string]bool{
+	"pre":      true,
+	"hr":       true,
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"iframe":   true,
+	"video":    true,
+	"canvas":   true,
+	"math":     true,
+	"address":  true,
+	"details":  true,
+	"nav":      true,
+	"main":     true,
+}
+
+func isBlockElement(n *htmlNode) bool {
+	return flowTags[n.tag] || blockLevelTags[n.tag]
+}
+
+func isSpaceText(n *htmlNode) bool {
+	return n.tag == "" && strings.TrimSpace(n.text) == ""
+}
+
+// blocks converts nodes to textile blocks, runs of inline nodes between
+// block elements become paragraphs
+func (c *htmlConverter) blocks(nodes []*htmlNode) []string {
+	var res []string
+	var run []*htmlNode
+	add := func(n *htmlNode) {
+		// empty paragraphs, e.g. of comments, are dropped
+		if s := c.block(n); s != "" {
+			res = append(res, s)
+		}
+	}
+	flush := func() {
+		for len(run) > 0 && isSpaceText(run[0]) {
+			run = run[1:]
+		}
+		if len(run) > 0 {
+			add(&htmlNode{tag: "p", children: run})
+		}
+		run = nil
+	}
+	for _, n := range nodes {
+		if n.tag != "" && isBlockElement(n) {
+			flush()
+			add(n)
+		} else {
+			run = append(run, n)
+		}
+	}
+	flush()
+	return res
+}
+
+// block converts n to a textile block, notextile. if textile doesn't
+// render it as n
+func (c *htmlConverter) block(n *htmlNode) string {
+	if flowTags[n.tag] && !textileBlockTags[n.tag] {
+		return c.htmlBlock(n)
+	}
+	// phrases can't always be written as textile, e.g. inside words, so
+	// try them as html too
+	for _, raw := range []bool{false, true} {
+		c.rawPhrases = raw
+		for _, s := range c.candidates(n) {
+			if c.rendersAs(s, n) {
+				c.rawPhrases = false
+				return s
+			}
+		}
+	}
+	c.rawPhrases = false
+	return noTextile(n)
+}
+
+// elements with textile blocks
+var textileBlockTags = map[string]bool{
+	"p":          true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"blockquote": true,
+	"pre":        true,
+	"ul":         true,
+	"ol":         true,
+	"table":      true,
+}
+
+// candidates returns ways to write n as a textile block
+func (c *htmlConverter) candidates(n *htmlNode) []string {
+	var candidates []string
+	switch n.tag {
+	case "p":
+		candidates = c.paragraph(n)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if attrs, ok := attrsOpt(n, false); ok {
+			if s, ok := c.inline(n.children); ok {
+				candidates = append(candidates, n.tag+attrs+". "+s)
+			}
+		}
+	case "blockquote":
+		if s, ok := c.blockQuote(n); ok {
+			candidates = append(candidates, s)
+		}
+	case "pre":
+		if s, ok := preformatted(n); ok {
+			candidates = append(candidates, s)
+		}
+	case "ul", "ol":
+		var lines []string
+		if c.list(n, 1, &lines) {
+			candidates = append(candidates, strings.Join(lines, "\n"))
+		}
+	case "table":
+		if s, ok := c.table(n); ok {
+			candidates = append(candidates, s)
+		}
+	}
+	return candidates
+}
+
+// rendersAs returns true if textile s is rendered as the html of n
+func (c *htmlConverter) rendersAs(s string, n *htmlNode) bool {
+	r := NewHtmlRenderer()
+	r.format = FormatCompact
+	html := string(Render(c.p.parse([]byte(s)), r))
+	got := parseHtmlFragment(html)
+	exp := &htmlNode{}
+	exp.children = []*htmlNode{n}
+	return canonicalHtml(got) == canonicalHtml(exp)
+}
+
+// noTextile returns n as a notextile. block
+func noTextile(n *htmlNode) string {
+	var buf strings.Builder
+	writeHtml(&buf, n)
+	s := buf.String()
+	// a blank line would end the block
+	for strings.Contains(s, "\n\n") {
+		s = strings.Replace(s, "\n\n", "\n&#10;", -1)
+	}
+	return "notextile. " + s
+}
+
+// htmlBlock converts an element like <div> to lines with its tags around
+// its content
+func (c *htmlConverter) htmlBlock(n *htmlNode) string {
+	var buf strings.Builder
+	writeStartTag(&buf, n)
+	start := buf.String()
+	end := "</" + n.tag + ">"
+	block := false
+	for _, child := range n.children {
+		block = block || child.tag != "" && isBlockElement(child)
+	}
+	if !block {
+		if s, ok := c.inline(n.children); ok && !strings.Contains(s, "\n") && c.rendersAs(start+s+end, n) {
+			return start + s + end
+		}
+		return noTextile(n)
+	}
+	blocks := append([]string{start}, c.blocks(n.children)...)
+	return strings.Join(append(blocks, end), "\n\n")
+}
+
+func (c *htmlConverter) paragraph(n *htmlNode) []string {
+	if s, ok := c.footnote(n); ok {
+		return []string{s}
+	}
+	attrs, ok := attrsOpt(n, false)
+	if !ok {
+		return nil
+	}
+	s, ok := c.inline(n.children)
+	if !ok {
+		return nil
+	}
+	if attrs == "" {
+		// p. for text that looks like a signature
+		return []string{s, "p. " + s}
+	}
+	return []string{"p" + attrs + ". " + s}
+}
+
+// footnote converts <p class="footnote" id="fn1"><sup>1</sup> text</p>
+func (c *htmlConverter) footnote(n *htmlNode) (string, bool) {
+	id := strings.TrimPrefix(n.attr("id"), "fn")
+	classes := strings.Fields(n.attr("class"))
+	if len(classes) == 0 || classes[0] != "footnote" || id == n.attr("id") || len(n.children) == 0 {
+		return "", false
+	}
+	sup := n.children[0]
+	if sup.tag != "sup" || textContent(sup) != id {
+		return "", false
+	}
+	attrs := &htmlNode{attrs: []htmlAttr{{name: "class", value: strings.Join(classes[1:], " ")}}}
+	for _, a := range n.attrs {
+		if a.name != "class" && a.name != "id" {
+			attrs.attrs = append(attrs.attrs, a)
+		}
+	}
+	opt, ok := attrsOpt(attrs, false)
+	if !ok {
+		return "", false
+	}
+	s, ok := c.inline(n.children[1:])
+	return "fn" + id + opt + ". " + s, ok
+}
+
+func (c *htmlConverter) blockQuote(n *htmlNode) (string, bool) {
+	if len(n.attrs) > 1 || len(n.attrs) == 1 && n.attrs[0].name != "cite" {
+		return "", false
+	}
+	var paras []*htmlNode
+	for _, child := range n.children {
+		if !isSpaceText(child) {
+			paras = append(paras, child)
+		}
+	}
+	content := n.children
+	if len(paras) == 1 && paras[0].tag == "p" && len(paras[0].attrs) == 0 {
+		content = paras[0].children
+	}
+	s, ok := c.inline(content)
+	if !ok {
+		return "", false
+	}
+	if cite := n.attr("cite"); cite != "" {
+		return "bq.:" + cite + " " + s, true
+	}
+	return "bq. " + s, true
+}
+
+// preformatted converts <pre> to pre. and <pre><code> to bc.
+func preformatted(n *htmlNode) (string, bool) {
+	if len(n.attrs) > 0 {
+		return "", false
+	}
+	signature := "pre. "
+	content := n.children
+	if len(content) == 1 && content[0].tag == "code" && len(content[0].attrs) == 0 {
+		signature = "bc. "
+		content = content[0].children
+	}
+	var text strings.Builder
+	for _, child := range content {
+		if child.tag != "" {
+			return "", false
+		}
+		text.WriteString(child.text)
+	}
+	s := strings.TrimSuffix(text.String(), "\n")
+	if strings.Contains(s, "\n\n") {
+		return "", false
+	}
+	return signature + s, true
+}
+
+// list appends lines of the items of a list at a nesting level to lines
+func (c *htmlConverter) list(n *htmlNode, level int, lines *[]string) bool {
+	if len(n.attrs) > 0 {
+		return false
+	}
+	marker := strings.Repeat("*", level)
+	if n.tag == "ol" {
+		marker = strings.Repeat("#", level)
+	}
+	for _, item := range n.children {
+		if isSpaceText(item) {
+			continue
+		}
+		if item.tag != "li" || len(item.attrs) > 0 {
+			return false
+		}
+		content := item.children
+		var nested []*htmlNode
+		for len(content) > 0 {
+			last := content[len(content)-1]
+			if last.tag == "ul" || last.tag == "ol" {
+				nested = append([]*htmlNode{last}, nested...)
+			} else if !isSpaceText(last) {
+				break
+			}
+			content = content[:len(content)-1]
+		}
+		s, ok := c.inline(content)
+		if !ok || strings.Contains(s, "\n") {
+			return false
+		}
+		*lines = append(*lines, marker+" "+s)
+		for _, list := range nested {
+			if !c.list(list, level+1, lines) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *htmlConverter) table(n *htmlNode) (string, bool) {
+	var lines []string
+	attrs, ok := attrsOpt(n, false)
+	if !ok {
+		return "", false
+	}
+	if attrs != "" {
+		lines = append(lines, "table"+attrs+".")
+	}
+	var rows []*htmlNode
+	for _, child := range n.children {
+		switch {
+		case isSpaceText(child):
+		case child.tag == "tr":
+			rows = append(rows, child)
+		case (child.tag == "thead" || child.tag == "tbody") && len(child.attrs) == 0:
+			for _, row := range child.children {
+				if !isSpaceText(row) {
+					rows = append(rows, row)
+				}
+			}
+		default:
+			return "", false
+		}
+	}
+	for _, row := range rows {
+		s, ok := c.tableRow(row)
+		if !ok {
+			return "", false
+		}
+		lines = append(lines, s)
+	}
+	return strings.Join(lines, "\n"), len(rows) > 0
+}
+
+func (c *htmlConverter) tableRow(n *htmlNode) (string, bool) {
+	if n.tag != "tr" {
+		return "", false
+	}
+	attrs, ok := attrsOpt(n, false)
+	if !ok {
+		return "", false
+	}
+	var buf strings.Builder
+	if attrs != "" {
+		buf.WriteString(attrs + ". ")
+	}
+	buf.WriteString("|")
+	for _, cell := range n.children {
+		if isSpaceText(cell) {
+			continue
+		}
+		if cell.tag != "td" && cell.tag != "th" {
+			return "", false
+		}
+		var spec string
+		if cell.tag == "th" {
+			spec = "_"
+		}
+		other := &htmlNode{}
+		for _, a := range cell.attrs {
+			switch a.name {
+			case "colspan":
+				spec += "\\" + a.value
+			case "rowspan":
+				spec += "/" + a.value
+			default:
+				other.attrs = append(other.attrs, a)
+			}
+		}
+		attrs, ok := attrsOpt(other, false)
+		s, ok2 := c.inline(cell.children)
+		if !ok || !ok2 || strings.ContainsAny(s, "|\n") {
+			return "", false
+		}
+		if spec+attrs != "" {
+			spec += attrs + ". "
+		}
+		buf.WriteString(spec + s + "|")
+	}
+	return buf.String(), true
+}
+
+// attrsOpt returns the attributes of n as ($class#$id){$style}[$lang], or
+// false if it has other attributes
+func attrsOpt(n *htmlNode, forImg bool) (string, bool) {
+	var attrs Attributes
+	for _, a := range n.attrs {
+		switch a.name {
+		case "class":
+			attrs.Class = strings.TrimSpace(a.value)
+		case "id":
+			attrs.ID = a.value
+		case "style":
+			attrs.Style = a.value
+		case "lang":
+			attrs.Lang = a.value
+		default:
+			return "", false
+		}
+	}
+	return textileAttrs(attrs, forImg), true
+}
+
+// normalizeStyle removes whitespace from a style and ends it with ;
+func normalizeStyle(s string) string {
+	s = strings.Join(strings.Fields(s), "")
+	if s != "" && !strings.HasSuffix(s, ";") {
+		s += ";"
+	}
+	return s
+}
+
+// phraseMarkers are the textile markers of phrase elements
+var phraseMarkers = map[string]string{
+	"em":     "_",
+	"strong": "*",
+	"i":      "__",
+	"b":      "**",
+	"cite":   "??",
+	"del":    "-",
+	"ins":    "+",
+	"sup":    "^",
+	"sub":    "~",
+	"span":   "%",
+}
+
+// inline converts inline nodes to textile, or returns false if they
+// contain blocks
+func (c *htmlConverter) inline(nodes []*htmlNode) (string, bool) {
+	var buf strings.Builder
+	for _, n := range nodes {
+		if !c.inlineNode(&buf, n) {
+			return "", false
+		}
+	}
+	// runs of spaces and spaces around line breaks aren't significant
+	lines := strings.Split(buf.String(), "\n")
+	for i, l := range lines {
+		for strings.Contains(l, "  ") {
+			l = strings.Replace(l, "  ", " ", -1)
+		}
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+func (c *htmlConverter) inlineNode(buf *strings.Builder, n *htmlNode) bool {
+	if n.tag == "" {
+		buf.WriteString(collapseSpace(n.text))
+		return true
+	}
+	if isBlockElement(n) {
+		return false
+	}
+	switch n.tag {
+	case "br":
+		buf.WriteString("\n")
+		return true
+	case "img":
+		if s, ok := imageTextile(n); ok {
+			buf.WriteString(s)
+			return true
+		}
+	case "code":
+		if len(n.attrs) == 0 && len(n.children) == 1 && n.children[0].tag == "" && !strings.Contains(n.children[0].text, "@") {
+			buf.WriteString("@" + n.children[0].text + "@")
+			return true
+		}
+	case "a":
+		return c.link(buf, n)
+	case "acronym", "abbr":
+		text := textContent(n)
+		if title := n.attr("title"); title != "" && len(n.attrs) == 1 && !strings.Contains(title, ")") {
+			buf.WriteString(text + "(" + title + ")")
+			return true
+		}
+	case "sup":
+		// footnote reference
+		if n.attr("class") == "footnote" && len(n.children) == 1 && n.children[0].tag == "a" {
+			id := textContent(n)
+			if n.children[0].attr("href") == "#fn"+id {
+				buf.WriteString("[" + id + "]")
+				return true
+			}
+		}
+	}
+	if marker, ok := phraseMarkers[n.tag]; ok {
+		if n.tag == "span" && n.attr("class") == "caps" && len(n.attrs) == 1 {
+			return c.inlineChildren(buf, n)
+		}
+		if attrs, ok := attrsOpt(n, false); ok && len(n.children) > 0 && !c.rawPhrases {
+			var inside strings.Builder
+			if !c.inlineChildren(&inside, n) {
+				return false
+			}
+			// spaces inside markers would end the phrase
+			s := inside.String()
+			trimmed := strings.TrimSpace(s)
+			if trimmed != "" && !strings.Contains(trimmed, "\n") {
+				if strings.HasPrefix(s, " ") {
+					buf.WriteString(" ")
+				}
+				buf.WriteString(marker + attrs + trimmed + marker)
+				if strings.HasSuffix(s, " ") {
+					buf.WriteString(" ")
+				}
+				return true
+			}
+		}
+	}
+	// inline html
+	writeStartTag(buf, n)
+	if voidTags[n.tag] {
+		return true
+	}
+	if !c.inlineChildren(buf, n) {
+		return false
+	}
+	buf.WriteString("</" + n.tag + ">")
+	return true
+}
+
+// collapseSpace replaces runs of whitespace in s with a space
+func collapseSpace(s string) string {
+	res := strings.Join(strings.Fields(s), " ")
+	if s != "" && isHtmlSpace(s[0]) {
+		res = " " + res
+	}
+	if res != " " && s != "" && isHtmlSpace(s[len(s)-1]) {
+		res += " "
+	}
+	return res
+}
+
+func (c *htmlConverter) inlineChildren(buf *strings.Builder, n *htmlNode) bool {
+	for _, child := range n.children {
+		if !c.inlineNode(buf, child) {
+			return false
+		}
+	}
+	return true
+}
+
+// link converts <a href="url">text</a> to "text":url
+func (c *htmlConverter) link(buf *strings.Builder, n *htmlNode) bool {
+	href := n.attr("href")
+	if href == "" || len(n.attrs) > 1 && !(len(n.attrs) == 2 && n.attr("class") == "img") {
+		writeStartTag(buf, n)
+		if !c.inlineChildren(buf, n) {
+			return false
+		}
+		buf.WriteString("</a>")
+		return true
+	}
+	// an image with a link
+	if len(n.children) == 1 && n.children[0].tag == "img" {
+		if s, ok := imageTextile(n.children[0]); ok {
+			buf.WriteString(s + ":" + href)
+			return true
+		}
+	}
+	var text strings.Builder
+	if !c.inlineChildren(&text, n) {
+		return false
+	}
+	buf.WriteString(`"` + strings.TrimSpace(text.String()) + `":` + href)
+	return true
+}
+
+// imageTextile converts <img> to !src(alt)!
+func imageTextile(n *htmlNode) (string, bool) {
+	src, alt, title := n.attr("src"), n.attr("alt"), n.attr("title")
+	if src == "" || title != "" && title != alt || strings.ContainsAny(alt, "()!") {
+		return "", false
+	}
+	other := &htmlNode{}
+	for _, a := range n.attrs {
+		switch a.name {
+		case "src", "alt", "title":
+		default:
+			other.attrs = append(other.attrs, a)
+		}
+	}
+	attrs, ok := attrsOpt(other, true)
+	if !ok {
+		return "", false
+	}
+	s := "!" + attrs + src
+	if alt != "" {
+		s += "(" + alt + ")"
+	}
+	return s + "!", true
+}
+
+// textContent returns the text of n and its descendants
+func textContent(n *htmlNode) string {
+	if n.tag == "" {
+		return n.text
+	}
+	var buf strings.Builder
+	for _, c := range n.children {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
+
+func writeStartTag(buf *strings.Builder, n *htmlNode) {
+	buf.WriteString("<" + n.tag)
+	for _, a := range n.attrs {
+		buf.WriteString(fmt.Sprintf(` %s="%s"`, a.name, escapeAttr(a.value)))
+	}
+	buf.WriteString(">")
+}
+
+// writeHtml writes n and its descendants as html
+func writeHtml(buf *strings.Builder, n *htmlNode) {
+	if n.tag == "" {
+		if n.parent != nil && (n.parent.tag == "script" || n.parent.tag == "style") {
+			buf.WriteString(n.text)
+		} else {
+			buf.WriteString(escapeWith(n.text, needsHtmlEscaping))
+		}
+		return
+	}
+	writeStartTag(buf, n)
+	if voidTags[n.tag] {
+		return
+	}
+	for _, c := range n.children {
+		writeHtml(buf, c)
+	}
+	buf.WriteString("</" + n.tag + ">")
+}
+
+func needsHtmlEscaping(b byte) []byte {
+	switch b {
+	case '&':
+		return []byte("&amp;")
+	case '<':
+		return []byte("&lt;")
+	case '>':
+		return []byte("&gt;")
+	}
+	return nil
+}
+
+// canonicalHtml returns the elements, attributes and text of the html tree
+// root in a form that doesn't depend on how they were written, e.g. on
+// whitespace, the order of attributes or acronyms as <abbr> or <acronym>
+func canonicalHtml(root *htmlNode) string {
+	var buf strings.Builder
+	for _, n := range root.children {
+		writeCanonical(&buf, n)
+	}
+	return buf.String()
+}
+
+func writeCanonical(buf *strings.Builder, n *htmlNode) {
+	if n.tag == "" {
+		text := strings.Join(strings.Fields(n.text), "")
+		// textile renders ' as &#8217;
+		buf.WriteString(strings.Replace(text, "'", "’", -1))
+		return
+	}
+	tag := n.tag
+	if tag == "abbr" {
+		tag = "acronym"
+	}
+	var attrs []string
+	for _, a := range n.attrs {
+		switch {
+		case a.name == "style":
+			a.value = normalizeStyle(a.value)
+		case tag == "img" && (a.name == "title" && a.value == n.attr("alt") || a.name == "alt" && a.value == ""):
+			continue
+		case tag == "a" && a.name == "class" && a.value == "img":
+			continue
+		case tag == "sup" && a.name == "id" && n.attr("class") == "footnote":
+			// only the first reference to a footnote has an id
+			continue
+		}
+		attrs = append(attrs, a.name+"="+a.value)
+	}
+	sort.Strings(attrs)
+	// the caps span only marks up acronyms and textile tables have no row
+	// groups
+	transparent := tag == "span" && len(attrs) == 1 && attrs[0] == "class=caps" ||
+		(tag == "thead" || tag == "tbody") && len(attrs) == 0
+	if !transparent {
+		buf.WriteString("<" + strings.Join(append([]string{tag}, attrs...), " ") + ">")
+	}
+	for _, c := range n.children {
+		writeCanonical(buf, c)
+	}
+	if !transparent && !voidTags[tag] {
+		buf.WriteString("</" + tag + ">")
+	}
+}
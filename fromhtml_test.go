@@ -0,0 +1,82 @@
+package textiler
+
+import (
+	"testing"
+)
+
+// roundTripFailures are the html of the corpus that doesn't survive
+// FromHtml and back, with the reason
+var roundTripFailures = map[string]string{
+	"<b> foo bar baz</b>\n\n\t<p>quux</p>":        "FromHtml puts the <b> outside of blocks into a paragraph",
+	"\t<p>line of text</p>\n\n    leading spaces": "FromHtml puts the text outside of blocks into a paragraph",
+}
+
+func TestFromHtmlRoundTrip(t *testing.T) {
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	failed := map[string]bool{}
+	for i := 1; i < len(corpus); i += 2 {
+		html1 := corpus[i]
+		textile := string(FromHtml([]byte(html1)))
+		html2 := textileToHtml(textile)
+		if canonicalHtml(parseHtmlFragment(html1)) != canonicalHtml(parseHtmlFragment(html2)) {
+			if _, known := roundTripFailures[html1]; !known {
+				t.Fatalf("\nHtml:%#v\n\nTextile:%#v\n\nGot:%#v\n", html1, textile, html2)
+			}
+			failed[html1] = true
+		}
+		// converting the result again doesn't change it
+		if again := string(FromHtml([]byte(html2))); again != textile {
+			t.Fatalf("\nHtml:%#v\n\nExp:%#v\n\nGot:%#v\n", html2, textile, again)
+		}
+	}
+	// a known failure that's fixed should be removed from the list
+	for html := range roundTripFailures {
+		if !failed[html] {
+			t.Errorf("round trip of %#v doesn't fail anymore", html)
+		}
+	}
+}
+
+func TestFromHtml(t *testing.T) {
+	tests := []string{
+		"<h2 class=\"x\">Title <em>here</em></h2>\n<p>Some <strong>bold </strong>text, <a href=\"http://x.com/\">a link</a> and <img src=\"a.png\" alt=\"A\"></p>",
+		"h2(x). Title _here_\n\nSome *bold* text, \"a link\":http://x.com/ and !a.png(A)!",
+
+		"<blockquote cite=\"http://c.com\"><p>quoted</p></blockquote><ul><li>a<ul><li>b</li></ul></li><li>c</li></ul><ol><li>x</li></ol>",
+		"bq.:http://c.com quoted\n\n* a\n** b\n* c\n\n# x",
+
+		"<p style=\"text-align: center\"><span style=\"color: red\">red</span> <abbr title=\"Cascading\">CSS</abbr> <code>x+1</code></p>",
+		"p=. %{color:red;}red% CSS(Cascading) @x+1@",
+
+		"<table class=\"t\"><thead><tr><th>a</th><th>b</th></tr></thead><tbody><tr class=\"r\"><td colspan=\"2\" style=\"color:red\">c</td></tr></tbody></table>",
+		"table(t).\n|_. a|_. b|\n(r). |\\2{color:red;}. c|",
+
+		// textile can't express phrases inside words and <hr>
+		"<div class=\"note\"><p>one</p><p>a<b>b</b>c</p></div><hr>",
+		"<div class=\"note\">\n\none\n\na<b>b</b>c\n\n</div>\n\nnotextile. <hr>",
+
+		// text that looks like a signature
+		"<p>h2. not a heading</p><p>line<br>\nbreak</p>",
+		"p. h2. not a heading\n\nline\nbreak",
+
+		"<pre><code>a &lt; b</code></pre><pre>x\n\ny</pre>",
+		"bc. a < b\n\nnotextile. <pre>x\n&#10;y</pre>",
+
+		"<p>a<sup class=\"footnote\" id=\"fnr1\"><a href=\"#fn1\">1</a></sup></p><p class=\"footnote\" id=\"fn1\"><sup>1</sup> Note</p>",
+		"a[1]\n\nfn1. Note",
+
+		"<p><a href=\"http://x.com\" class=\"img\"><img src=\"a.png\" alt=\"\" style=\"float: left;\"></a></p>",
+		"!<a.png!:http://x.com",
+
+		"loose <!-- comment --> text<script>x < y</script>",
+		"loose text\n\nnotextile. <script>x < y</script>",
+
+		"<p>text</p><!",
+		"text",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		if got := string(FromHtml([]byte(tests[i]))); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package textiler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// isFrontMatterDelim returns true if l is a --- line, which starts and
+// ends the front matter
+func isFrontMatterDelim(l []byte) bool {
+	return string(bytes.TrimRight(l, " \t")) == "---"
+}
+
+// parseMetaLine parses a key: value line of the front matter. Keys are
+// words of letters, digits, '_', '-' and '.'; values are trimmed and
+// unquoted if they're in single or double quotes. Empty lines and # comments
+// are ok, with an empty key.
+func parseMetaLine(l []byte) (key, value string, ok bool) {
+	s := strings.TrimSpace(string(l))
+	if s == "" || s[0] == '#' {
+		return "", "", true
+	}
+	i := 0
+	for i < len(s) && (isChar(s[i]) || isDigit(s[i]) || s[i] == '_' || s[i] == '-' || s[i] == '.') {
+		i++
+	}
+	if i == 0 || i == len(s) || s[i] != ':' || i+1 < len(s) && s[i+1] != ' ' && s[i+1] != '\t' {
+		return "", "", false
+	}
+	value = strings.TrimSpace(s[i+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return s[:i], value, true
+}
+
+// frontMatterEnd returns the index of the --- line that ends the front
+// matter at the start of lines, 0 if they don't start with front matter
+func frontMatterEnd(lines [][]byte) int {
+	if len(lines) == 0 || !isFrontMatterDelim(lines[0]) {
+		return 0
+	}
+	for i := 1; i < len(lines); i++ {
+		if isFrontMatterDelim(lines[i]) {
+			return i
+		}
+		if _, _, ok := parseMetaLine(lines[i]); !ok {
+			return 0
+		}
+	}
+	return 0
+}
+
+// setMeta sets the metadata of the document to the key: value lines of the
+// front matter, later values of a key replace earlier ones
+func (p *TextileParser) setMeta(lines [][]byte) {
+	p.doc.Meta = make(map[string]string)
+	for _, l := range lines {
+		if key, value, _ := parseMetaLine(l); key != "" {
+			p.doc.Meta[key] = value
+		}
+	}
+}
+
+// titleFrom sets the title of the document to the text of n if it's the
+// first h1. and the front matter doesn't have a title
+func (p *TextileParser) titleFrom(n Node) {
+	h, ok := n.(*Heading)
+	if !ok || h.Level != 1 || p.doc.Meta["title"] != "" {
+		return
+	}
+	if p.doc.Meta == nil {
+		p.doc.Meta = make(map[string]string)
+	}
+	p.doc.Meta["title"] = textOf(h)
+}
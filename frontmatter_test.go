@@ -0,0 +1,122 @@
+package textiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		src  string
+		html string
+		meta map[string]string
+	}{
+		{
+			"---\ntitle: Hello\nauthor:  \"Jane Doe\"\n# a comment\n\ntags: [a, b]\ndate: '2024-01-02'\n---\nh1. Other\n\nText.",
+			"\t<h1>Other</h1>\n\n\t<p>Text.</p>",
+			map[string]string{"title": "Hello", "author": "Jane Doe", "tags": "[a, b]", "date": "2024-01-02"},
+		},
+		{
+			"---\ntitle: x\nnot meta\n---\nText.",
+			"\t<p>---<br>\ntitle: x<br>\nnot meta<br>\n---<br>\nText.</p>",
+			nil,
+		},
+		{
+			"---\ntitle: x",
+			"\t<p>---<br>\ntitle: x</p>",
+			nil,
+		},
+		{
+			"Text\n---\ntitle: x\n---",
+			"\t<p>Text<br>\n---<br>\ntitle: x<br>\n---</p>",
+			nil,
+		},
+		{
+			"---\n---\n\nText.",
+			"\t<p>Text.</p>",
+			map[string]string{},
+		},
+	}
+	opts := &Options{FrontMatter: true}
+	for _, test := range tests {
+		res, meta, _, err := ConvertWithMeta([]byte(test.src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res) != test.html || !reflect.DeepEqual(meta, test.meta) {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v %v\n\nGot:%#v %v\n", test.src, test.html, test.meta, string(res), meta)
+		}
+		got, err := convertString(test.src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.html {
+			t.Fatalf("Convert\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", test.src, test.html, got)
+		}
+	}
+	if got := convertBytes(t, "---\ntitle: x\n---", nil); got != "\t<p>---<br>\ntitle: x<br>\n---</p>" {
+		t.Fatalf("%#v", got)
+	}
+}
+
+func TestFrontMatterPositions(t *testing.T) {
+	src := "---\na: b\n---\nSome %span"
+	opts := &Options{FrontMatter: true}
+	_, _, diags, err := ConvertWithMeta([]byte(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Pos.String() != "4:6" {
+		t.Fatalf("%v", diags)
+	}
+	var buf bytes.Buffer
+	diags, err = Convert(&buf, strings.NewReader(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Pos.String() != "4:6" {
+		t.Fatalf("Convert: %v", diags)
+	}
+}
+
+func TestTitleFromHeading(t *testing.T) {
+	tests := []struct {
+		src   string
+		title string
+	}{
+		{"h2. Sub\n\nh1. Heading *one*\n\nh1. Two", "Heading one"},
+		{"---\ntitle: Given\n---\nh1. Heading", "Given"},
+		{"---\ntitle:\n---\nh1. Heading", "Heading"},
+	}
+	opts := &Options{FrontMatter: true, TitleFromHeading: true}
+	for _, test := range tests {
+		_, meta, _, err := ConvertWithMeta([]byte(test.src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta["title"] != test.title {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", test.src, test.title, meta["title"])
+		}
+	}
+	if _, meta, _, _ := ConvertWithMeta([]byte("Text."), opts); meta != nil {
+		t.Fatalf("%v", meta)
+	}
+
+	p := getParser(opts)
+	doc := p.parse([]byte("---\nauthor: me\n---\nh1. Title"))
+	data, err := json.Marshal(doc)
+	putParser(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"author": "me", "title": "Title"}; !reflect.DeepEqual(decoded.Meta, exp) {
+		t.Fatalf("%s", data)
+	}
+}
@@ -0,0 +1,110 @@
+package textiler
+
+import (
+	"strings"
+	"unicode"
+)
+
+var symbolGlyphs = []struct {
+	s string
+	r rune
+}{
+	{"(c)", '©'},
+	{"(r)", '®'},
+	{"(tm)", '™'},
+}
+
+// startsQuote returns true if a quote after prev opens a quotation
+func startsQuote(prev rune) bool {
+	return prev == 0 || unicode.IsSpace(prev) || strings.ContainsRune("([{-", prev)
+}
+
+// glyphs returns s with quotes, dashes, ellipses, multiplication signs and
+// (c), (r), (tm) replaced with typographic characters. prev is the
+// character before s (0 at the start of a block).
+func glyphs(s string, prev rune) string {
+	in := []rune(s)
+	at := func(i int) rune {
+		if i < 0 {
+			if i == -1 {
+				return prev
+			}
+			return 0
+		}
+		if i >= len(in) {
+			return 0
+		}
+		return in[i]
+	}
+	out := make([]rune, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		switch c {
+		case '"':
+			if startsQuote(at(i - 1)) {
+				c = '“'
+			} else {
+				c = '”'
+			}
+		case '\'':
+			if startsQuote(at(i-1)) && unicode.IsLetter(at(i+1)) {
+				c = '‘'
+			} else {
+				c = '’'
+			}
+		case '-':
+			if at(i+1) == '-' {
+				c = '—'
+				i += 1
+			} else if at(i-1) == ' ' && at(i+1) == ' ' {
+				c = '–'
+			}
+		case '.':
+			if at(i+1) == '.' && at(i+2) == '.' {
+				c = '…'
+				i += 2
+			}
+		case 'x':
+			before, after := at(i-1), at(i+1)
+			if before == ' ' && after == ' ' {
+				before, after = at(i-2), at(i+2)
+			}
+			if unicode.IsDigit(before) && unicode.IsDigit(after) {
+				c = '×'
+			}
+		case '(':
+			for _, g := range symbolGlyphs {
+				end := i + len(g.s)
+				if end <= len(in) && strings.EqualFold(string(in[i:end]), g.s) {
+					c = g.r
+					i = end - 1
+					break
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// applyGlyphs replaces characters in text of n with typographic glyphs,
+// except in code and html
+func applyGlyphs(n Node) {
+	var prev rune
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *CodeBlock, *HTMLBlock:
+			return false
+		case *LineBreak:
+			prev = ' '
+		case *Acronym:
+			prev = 'A'
+		case *Text:
+			n.Text = glyphs(n.Text, prev)
+			if s := []rune(n.Text); len(s) > 0 {
+				prev = s[len(s)-1]
+			}
+		}
+		return true
+	})
+}
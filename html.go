@@ -0,0 +1,562 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// HtmlRenderer renders html compatible with the output of python-textile
+type HtmlRenderer struct {
+	// nesting level of block quotes
+	quoteLevel int
+	// number of lists and list items we're in
+	listLevel int
+	itemLevel int
+	// footnotes that were referenced, the first reference gets an id
+	footnoteRefs map[string]bool
+	lineBreaks   LineBreaks
+	format       Format
+	// indentation of one level and the nesting level for FormatPretty
+	indentBy string
+	depth    int
+	// prepended to generated ids
+	idPrefix string
+}
+
+// XhtmlRenderer renders xhtml. It differs from HtmlRenderer in closing
+// empty elements (<br />, <img />) and in re-serializing raw html, so that
+// the output is well-formed xml, see RawHTML.
+type XhtmlRenderer struct {
+	*HtmlRenderer
+	// elements opened by raw html that aren't closed yet
+	open []xhtmlElement
+	// number of nodes with children we're in
+	nodes int
+}
+
+// Html5Renderer renders html5: <abbr> instead of <acronym> and <figure> for
+// paragraphs that are a single captioned image. Phrases keep their tags:
+// __i__ and **b** stay <i> and <b>, which html5 defines as text in an
+// alternate voice and text that is stylistically offset, i.e. what authors
+// use instead of _em_ and *strong* when they don't mean emphasis.
+type Html5Renderer struct {
+	*HtmlRenderer
+	// image of the figure being rendered
+	figure *Image
+}
+
+func NewHtmlRenderer() *HtmlRenderer {
+	return &HtmlRenderer{footnoteRefs: make(map[string]bool), indentBy: "\t"}
+}
+
+func NewXhtmlRenderer() *XhtmlRenderer {
+	return &XhtmlRenderer{HtmlRenderer: NewHtmlRenderer()}
+}
+
+func NewHtml5Renderer() *Html5Renderer {
+	return &Html5Renderer{HtmlRenderer: NewHtmlRenderer()}
+}
+
+// newRenderer returns a renderer for renderer flags (e.g. RENDERER_XHTML)
+func newRenderer(flags int) Renderer {
+	if flags&RENDERER_XHTML != 0 {
+		return NewXhtmlRenderer()
+	}
+	return NewHtmlRenderer()
+}
+
+func needsHtmlCodeEscaping(b byte) []byte {
+	switch b {
+
+	/*	case '"':
+		return []byte("&quot;")*/
+	case '&':
+		return []byte("&amp;")
+	case '<':
+		return []byte("&lt;")
+	case '>':
+		return []byte("&gt;")
+	case '\'':
+		return []byte("&#8217;")
+	}
+	return nil
+}
+
+func needsAttrEscaping(b byte) []byte {
+	switch b {
+	case '"':
+		return []byte("&quot;")
+	case '&':
+		return []byte("&amp;")
+	case '<':
+		return []byte("&lt;")
+	case '>':
+		return []byte("&gt;")
+	}
+	return nil
+}
+
+// code is escaped like attributes, except that quotes are kept as they are
+func needsCodeEscaping(b byte) []byte {
+	if b == '"' {
+		return nil
+	}
+	return needsAttrEscaping(b)
+}
+
+func escapeWith(s string, needsEscaping func(b byte) []byte) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if esc := needsEscaping(b); esc != nil {
+			buf.Write(esc)
+		} else {
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+// glyphEntities are characters produced by glyphs that are written as
+// numeric entities, like python-textile does
+var glyphEntities = map[rune]bool{
+	'‘': true, '’': true, '“': true, '”': true, '–': true, '—': true,
+	'…': true, '×': true, '©': true, '®': true, '™': true,
+}
+
+func escapeText(s string) string {
+	var buf bytes.Buffer
+	for _, c := range s {
+		if c < utf8.RuneSelf {
+			if esc := needsHtmlCodeEscaping(byte(c)); esc != nil {
+				buf.Write(esc)
+				continue
+			}
+		} else if glyphEntities[c] {
+			fmt.Fprintf(&buf, "&#%d;", c)
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+func escapeAttr(s string) string {
+	return escapeWith(s, needsAttrEscaping)
+}
+
+func prettyPrintStyle(s []byte) []byte {
+	res := make([]byte, 0)
+	state := 0 // 0 - regular, 1 - after ';'
+	for _, b := range s {
+		if state == 0 {
+			res = append(res, b)
+			if b == ';' {
+				state = 1
+			}
+		} else {
+			if b != ' ' {
+				res = append(res, ' ')
+				res = append(res, b)
+				state = 0
+			}
+		}
+	}
+	if !endsWithByte(res, ';') {
+		res = append(res, ';')
+	}
+	return res
+}
+
+// returns ' class="$class" id="$id"'
+func serClassAndId(class, id string) string {
+	s := ""
+	if class != "" {
+		s += fmt.Sprintf(` class="%s"`, escapeAttr(class))
+	}
+	if id != "" {
+		s += fmt.Sprintf(` id="%s"`, escapeAttr(id))
+	}
+	return s
+}
+
+func serStyleOpt(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	s = string(prettyPrintStyle([]byte(s)))
+	return fmt.Sprintf(` style="%s"`, escapeAttr(s))
+}
+
+func serLangOpt(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(` lang="%s"`, escapeAttr(s))
+}
+
+func serAttributes(attrs Attributes) string {
+	s1 := serClassAndId(attrs.Class, attrs.ID)
+	s2 := serStyleOpt(attrs.Style)
+	s3 := serLangOpt(attrs.Lang)
+	return s1 + s2 + s3
+}
+
+func (r *HtmlRenderer) indent() string {
+	return strings.Repeat("\t", r.quoteLevel+1)
+}
+
+// layout writes whitespace around a tag: s in FormatTextile, a newline and
+// the indentation of the current level in FormatPretty if newline is set
+// and nothing in FormatCompact
+func (r *HtmlRenderer) layout(out *bytes.Buffer, s string, newline bool) {
+	switch r.format {
+	case FormatTextile:
+		out.WriteString(s)
+	case FormatPretty:
+		if newline {
+			out.WriteString("\n")
+			out.WriteString(strings.Repeat(r.indentBy, r.depth))
+		}
+	}
+}
+
+// nested returns true if a block being started is inside another one
+func (r *HtmlRenderer) nested() bool {
+	return r.depth > 0
+}
+
+func (r *HtmlRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.quoteLevel = 0
+	r.listLevel = 0
+	r.itemLevel = 0
+	r.depth = 0
+	r.footnoteRefs = make(map[string]bool)
+}
+
+func (r *HtmlRenderer) DocumentFooter(out *bytes.Buffer) {
+}
+
+func (r *HtmlRenderer) BlockSeparator(out *bytes.Buffer) {
+	switch r.format {
+	case FormatTextile:
+		out.WriteString("\n\n")
+	case FormatPretty:
+		out.WriteString("\n")
+	}
+}
+
+func (r *HtmlRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if entering {
+		r.layout(out, r.indent(), r.nested())
+		out.WriteString(fmt.Sprintf("<p%s>", serAttributes(n.Attrs)))
+	} else {
+		out.WriteString("</p>")
+	}
+}
+
+func (r *HtmlRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		r.layout(out, r.indent(), r.nested())
+		out.WriteString(fmt.Sprintf("<h%d%s>", n.Level, serAttributes(n.Attrs)))
+	} else {
+		out.WriteString(fmt.Sprintf("</h%d>", n.Level))
+	}
+}
+
+func serCite(cite string) string {
+	if cite == "" {
+		return ""
+	}
+	return fmt.Sprintf(` cite="%s"`, escapeAttr(cite))
+}
+
+func (r *HtmlRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	if entering {
+		r.layout(out, r.indent(), r.nested())
+		out.WriteString(fmt.Sprintf("<blockquote%s%s>", serAttributes(n.Attrs), serCite(n.Cite)))
+		r.layout(out, "\n", false)
+		r.quoteLevel += 1
+		r.depth += 1
+	} else {
+		r.quoteLevel -= 1
+		r.depth -= 1
+		r.layout(out, "\n"+r.indent(), true)
+		out.WriteString("</blockquote>")
+	}
+}
+
+func listTag(n *List) string {
+	if n.Ordered {
+		return "ol"
+	}
+	return "ul"
+}
+
+func (r *HtmlRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if entering {
+		// a nested list starts on a new line
+		s := "\t"
+		if r.itemLevel > 0 && r.itemLevel == r.listLevel {
+			s = "\n\t"
+		}
+		r.layout(out, s, r.nested())
+		r.listLevel += 1
+		r.depth += 1
+		out.WriteString(fmt.Sprintf("<%s%s>", listTag(n), serAttributes(n.Attrs)))
+	} else {
+		r.listLevel -= 1
+		r.depth -= 1
+		r.layout(out, "\n\t", true)
+		out.WriteString(fmt.Sprintf("</%s>", listTag(n)))
+	}
+}
+
+func (r *HtmlRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	if entering {
+		r.layout(out, "\n\t\t", true)
+		r.itemLevel += 1
+		r.depth += 1
+		out.WriteString("<li>")
+	} else {
+		r.itemLevel -= 1
+		r.depth -= 1
+		// the end tag of an item with a nested list is on its own line
+		_, nested := n.lastChild().(*List)
+		r.layout(out, "", nested)
+		out.WriteString("</li>")
+	}
+}
+
+func (r *HtmlRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	code := escapeWith(n.Text, needsCodeEscaping)
+	r.layout(out, "", r.nested())
+	if n.Preformatted {
+		out.WriteString(fmt.Sprintf("<pre%s>%s\n</pre>", serAttributes(n.Attrs), code))
+		return
+	}
+	out.WriteString(fmt.Sprintf("<pre%s><code>%s\n</code></pre>", serAttributes(n.Attrs), code))
+}
+
+func (r *HtmlRenderer) HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool) {
+}
+
+func (r *HtmlRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if entering {
+		r.layout(out, "\t", r.nested())
+		out.WriteString(fmt.Sprintf("<table%s>", serAttributes(n.Attrs)))
+		r.depth += 1
+	} else {
+		r.depth -= 1
+		r.layout(out, "\n\t", true)
+		out.WriteString("</table>")
+	}
+}
+
+func (r *HtmlRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	if entering {
+		r.layout(out, "\n\t\t", true)
+		out.WriteString(fmt.Sprintf("<tr%s>", serAttributes(n.Attrs)))
+		r.depth += 1
+	} else {
+		r.depth -= 1
+		r.layout(out, "\n\t\t", true)
+		out.WriteString("</tr>")
+	}
+}
+
+func cellTag(n *TableCell) string {
+	if n.Header {
+		return "th"
+	}
+	return "td"
+}
+
+func (r *HtmlRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	if !entering {
+		out.WriteString(fmt.Sprintf("</%s>", cellTag(n)))
+		return
+	}
+	s := serAttributes(n.Attrs)
+	if n.Colspan > 0 {
+		s += fmt.Sprintf(` colspan="%d"`, n.Colspan)
+	}
+	if n.Rowspan > 0 {
+		s += fmt.Sprintf(` rowspan="%d"`, n.Rowspan)
+	}
+	r.layout(out, "\n\t\t\t", true)
+	out.WriteString(fmt.Sprintf("<%s%s>", cellTag(n), s))
+}
+
+func (r *HtmlRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	if !entering {
+		out.WriteString("</p>")
+		return
+	}
+	attrs := n.Attrs
+	attrs.Class = strings.TrimSpace("footnote " + attrs.Class)
+	attrs.ID = r.idPrefix + "fn" + n.ID
+	r.layout(out, r.indent(), r.nested())
+	out.WriteString(fmt.Sprintf("<p%s><sup>%s</sup> ", serAttributes(attrs), escapeAttr(n.ID)))
+}
+
+func (r *HtmlRenderer) Text(out *bytes.Buffer, n *Text) {
+	out.WriteString(escapeText(n.Text))
+}
+
+// lineBreak writes a line break, using br as the tag if line breaks are
+// rendered as tags
+func (r *HtmlRenderer) lineBreak(out *bytes.Buffer, br string) {
+	switch r.lineBreaks {
+	case LineBreaksNewline:
+		out.WriteString("\n")
+	case LineBreaksSpace:
+		out.WriteString(" ")
+	default:
+		out.WriteString(br)
+		if r.format != FormatCompact {
+			out.WriteString("\n")
+		}
+	}
+}
+
+func (r *HtmlRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	r.lineBreak(out, "<br>")
+}
+
+func (r *HtmlRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	if entering {
+		out.WriteString(fmt.Sprintf("<%s%s>", n.Tag, serAttributes(n.Attrs)))
+	} else {
+		out.WriteString(fmt.Sprintf("</%s>", n.Tag))
+	}
+}
+
+func (r *HtmlRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+	if entering {
+		out.WriteString(fmt.Sprintf("<span%s>", serAttributes(n.Attrs)))
+	} else {
+		out.WriteString("</span>")
+	}
+}
+
+func (r *HtmlRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	out.WriteString(fmt.Sprintf("<code>%s</code>", escapeWith(n.Text, needsCodeEscaping)))
+}
+
+// serAcronym writes an acronym as the element tag, e.g. acronym or abbr
+func serAcronym(out *bytes.Buffer, n *Acronym, tag string) {
+	out.WriteString(fmt.Sprintf(`<%s title="%s"><span class="caps">%s</span></%s>`, tag, escapeAttr(n.Title), escapeText(n.Text), tag))
+}
+
+func (r *HtmlRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	serAcronym(out, n, "acronym")
+}
+
+func (r *HtmlRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if entering {
+		class := ""
+		if n.Missing {
+			class = ` class="missing"`
+		}
+		out.WriteString(fmt.Sprintf(`<a href="%s"%s>`, escapeAttr(n.URL), class))
+	} else {
+		out.WriteString("</a>")
+	}
+}
+
+// serImg writes <img> closed with end, either ">" or " />"
+func serImg(out *bytes.Buffer, n *Image, end string) {
+	if len(n.URL) > 0 {
+		out.WriteString(fmt.Sprintf(`<a href="%s" class="img">`, escapeAttr(n.URL)))
+	}
+	s := ""
+	if len(n.Attrs.Style) > 0 {
+		s += fmt.Sprintf(` style="%s"`, escapeAttr(n.Attrs.Style))
+	}
+	s += serClassAndId(n.Attrs.Class, n.Attrs.ID)
+	if len(n.Alt) > 0 {
+		s += fmt.Sprintf(` title="%s"`, escapeAttr(n.Alt))
+	}
+	s += fmt.Sprintf(` alt="%s"`, escapeAttr(n.Alt))
+	if n.Width > 0 && n.Height > 0 {
+		s += fmt.Sprintf(` width="%d" height="%d"`, n.Width, n.Height)
+	}
+	if n.Loading != "" {
+		s += fmt.Sprintf(` loading="%s"`, escapeAttr(n.Loading))
+	}
+	out.WriteString(fmt.Sprintf(`<img src="%s"%s%s`, escapeAttr(n.Src), s, end))
+	if len(n.URL) > 0 {
+		out.WriteString("</a>")
+	}
+}
+
+func (r *HtmlRenderer) Image(out *bytes.Buffer, n *Image) {
+	serImg(out, n, ">")
+}
+
+func (r *HtmlRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	out.WriteString(n.HTML)
+}
+
+func (r *HtmlRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	id := ""
+	if !r.footnoteRefs[n.ID] {
+		r.footnoteRefs[n.ID] = true
+		id = fmt.Sprintf(` id="%sfnrev%s"`, escapeAttr(r.idPrefix), escapeAttr(n.ID))
+	}
+	out.WriteString(fmt.Sprintf(`<sup class="footnote"%s><a href="#%sfn%s">%s</a></sup>`, id, escapeAttr(r.idPrefix), escapeAttr(n.ID), escapeAttr(n.ID)))
+}
+
+func (r *XhtmlRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	r.lineBreak(out, "<br />")
+}
+
+func (r *XhtmlRenderer) Image(out *bytes.Buffer, n *Image) {
+	start := out.Len()
+	serImg(out, n, " />")
+	dropNonXmlChars(out, start)
+}
+
+// figureImage returns the image of a paragraph that is rendered as a
+// <figure>: a single image with alt text, which is the caption
+func figureImage(n *Paragraph) *Image {
+	if len(n.Nodes) != 1 {
+		return nil
+	}
+	img, ok := n.Nodes[0].(*Image)
+	if !ok || img.Alt == "" {
+		return nil
+	}
+	return img
+}
+
+func (r *Html5Renderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if entering {
+		if r.figure = figureImage(n); r.figure != nil {
+			r.layout(out, r.indent(), r.nested())
+			out.WriteString(fmt.Sprintf("<figure%s>", serAttributes(n.Attrs)))
+			return
+		}
+		r.HtmlRenderer.Paragraph(out, n, entering)
+		return
+	}
+	if r.figure != nil {
+		out.WriteString(fmt.Sprintf("<figcaption>%s</figcaption></figure>", escapeText(r.figure.Alt)))
+		r.figure = nil
+		return
+	}
+	r.HtmlRenderer.Paragraph(out, n, entering)
+}
+
+func (r *Html5Renderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	serAcronym(out, n, "abbr")
+}
+
+// RenderHtml renders a document as html. flags are renderer flags
+// (e.g. RENDERER_XHTML).
+func RenderHtml(doc *Document, flags int) []byte {
+	return Render(doc, newRenderer(flags))
+}
@@ -0,0 +1,130 @@
+package textiler
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tagRe = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9]*)[^>]*?(/?)>`)
+
+// checkContentModel returns a description of the first flow element inside
+// a <p> in html, or "" if there's none
+func checkContentModel(html string) string {
+	var stack []string
+	for _, m := range tagRe.FindAllStringSubmatch(html, -1) {
+		end, tag := m[1] == "/", strings.ToLower(m[2])
+		if voidTags[tag] || m[3] == "/" {
+			continue
+		}
+		if end {
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == tag {
+					stack = stack[:i]
+					break
+				}
+				if stack[i] == "p" && isFlowTag(tag) {
+					return "</" + tag + "> inside <p>"
+				}
+			}
+			continue
+		}
+		if isFlowTag(tag) {
+			for _, t := range stack {
+				if t == "p" {
+					return "<" + tag + "> inside <p>"
+				}
+			}
+		}
+		stack = append(stack, tag)
+	}
+	return ""
+}
+
+func TestContentModel(t *testing.T) {
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	for _, flavor := range []Flavor{FlavorHtml, FlavorXhtml, FlavorHtml5} {
+		opts := &Options{Flavor: flavor}
+		for i := 0; i < len(corpus); i += 2 {
+			s := corpus[i]
+			got := convertBytes(t, s, opts)
+			if msg := checkContentModel(got); msg != "" {
+				t.Fatalf("%s\nSrc:%#v\n\nGot:%#v\n", msg, s, got)
+			}
+			if flavor == FlavorHtml5 && (strings.Contains(got, "<acronym") || strings.Contains(got, " align=")) {
+				t.Fatalf("legacy markup\nSrc:%#v\n\nGot:%#v\n", s, got)
+			}
+		}
+	}
+}
+
+func TestContentModelInline(t *testing.T) {
+	var inputs []string
+	for _, before := range []string{"a ", "*a ", "h2. a ", "bq. a ", "* a ", "|a ", "<pre>\na ", "<div>\na ", "<div>a "} {
+		for _, html := range []string{"<div>", "</div>", "<div>x</div>", "<ul><li>x</li></ul>", "<table><tr><td>x</td></tr></table>", "<pre>x</pre>", "<p>x</p>", "<hr>"} {
+			inputs = append(inputs, before+html+" b", before+html)
+		}
+	}
+	// tags at the start of lines
+	inputs = append(inputs, "a\n</pre> b", "a\n<hr> b", "<p>a <div> b</p>", "<h1>a <ul><li>b</li></ul></h1>", "<div><p>a</p></div>")
+	for _, flavor := range []Flavor{FlavorHtml, FlavorXhtml, FlavorHtml5} {
+		opts := &Options{Flavor: flavor}
+		for _, s := range inputs {
+			got := convertBytes(t, s, opts)
+			if msg := checkContentModel(got); msg != "" {
+				t.Fatalf("%s\nSrc:%#v\n\nGot:%#v\n", msg, s, got)
+			}
+		}
+	}
+}
+
+func TestHtml5(t *testing.T) {
+	tests := []string{
+		"Regardless:\n* a server, which accepts\n\nh3. The server",
+		"\t<p>Regardless:</p>\n\n\t<ul>\n\t\t<li>a server, which accepts</li>\n\t</ul>\n\n\t<h3>The server</h3>",
+
+		"We use CSS(Cascading Style Sheets).",
+		"\t<p>We use <abbr title=\"Cascading Style Sheets\"><span class=\"caps\">CSS</span></abbr>.</p>",
+
+		"!cat.png(A cat)!\n\n!dog.png! and text",
+		"\t<figure><img src=\"cat.png\" title=\"A cat\" alt=\"A cat\"><figcaption>A cat</figcaption></figure>\n\n" +
+			"\t<p><img src=\"dog.png\" alt=\"\"> and text</p>",
+
+		"bq.:http://example.com/speech Quoted",
+		"\t<blockquote cite=\"http://example.com/speech\">\n\t\t<p>Quoted</p>\n\t</blockquote>",
+
+		"__alternate__ **offset** _em_",
+		"\t<p><i>alternate</i> <b>offset</b> <em>em</em></p>",
+
+		"Text\n<div>\ninside\n</div>",
+		"\t<p>Text</p>\n\n<div>\n\n\t<p>inside</p>\n\n</div>",
+	}
+	opts := &Options{Flavor: FlavorHtml5}
+	for i := 0; i < len(tests); i += 2 {
+		s := tests[i]
+		if got := convertBytes(t, s, opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, tests[i+1], got)
+		}
+	}
+}
+
+// Acronyms are parsed for every flavor, FlavorHtml renders them as
+// python-textile does
+func TestAcronyms(t *testing.T) {
+	tests := []string{
+		"What is AJAX(Asynchronous Javascript and XML)?",
+		"\t<p>What is <acronym title=\"Asynchronous Javascript and XML\"><span class=\"caps\">AJAX</span></acronym>?</p>",
+
+		// not acronyms
+		"A(b) aBC(d) ABC() ABC (d) 2D(two)",
+		"\t<p>A(b) aBC(d) ABC() ABC (d) 2D(two)</p>",
+
+		"bq.:http://example.com Quoted",
+		"\t<blockquote cite=\"http://example.com\">\n\t\t<p>Quoted</p>\n\t</blockquote>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		if got := textileToHtml(tests[i]); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
@@ -1,5 +1,33 @@
 package textiler
 
+// elements that can't be inside a paragraph, lines starting with them are
+// blocks of their own
+var flowTags = map[string]bool{
+	"p":          true,
+	"dl":         true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"ol":         true,
+	"ul":         true,
+	"div":        true,
+	"form":       true,
+	"table":      true,
+	"fieldset":   true,
+	"blockquote": true,
+	"aside":      true,
+	"figure":     true,
+	"footer":     true,
+	"header":     true,
+	"hgroup":     true,
+	"article":    true,
+	"section":    true,
+	"figcaption": true,
+}
+
 // html blocks that don't need escaping
 var blockTags = map[string]bool{
 	"b":          true,
@@ -0,0 +1,341 @@
+package textiler
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type htmlTokenType int
+
+const (
+	htmlText htmlTokenType = iota
+	htmlStartTag
+	htmlEndTag
+	htmlComment
+)
+
+type htmlAttr struct {
+	name  string
+	value string
+	// the attribute has no value, e.g. <input disabled>
+	bare bool
+}
+
+type htmlToken struct {
+	typ   htmlTokenType
+	tag   string
+	attrs []htmlAttr
+	// text of text tokens and comments
+	text string
+	// is the start tag closed with />?
+	selfClosing bool
+}
+
+// htmlTokenizer splits html into tokens the way browsers do, for a subset
+// of html: it knows tags, attributes with or without quotes, comments,
+// <!doctype> and elements whose content is raw text like <script>
+type htmlTokenizer struct {
+	s   string
+	pos int
+	// element whose raw text content comes next
+	rawTag string
+}
+
+// elements whose content is text, not html
+var rawTextTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+func isHtmlSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// next returns the next token or false at the end of input
+func (z *htmlTokenizer) next() (htmlToken, bool) {
+	if z.pos >= len(z.s) {
+		return htmlToken{}, false
+	}
+	if z.rawTag != "" {
+		return z.rawText(), true
+	}
+	s := z.s[z.pos:]
+	if s[0] == '<' && len(s) > 1 {
+		c := s[1]
+		switch {
+		case isChar(c):
+			return z.tag(htmlStartTag, 1), true
+		case c == '/' && len(s) > 2 && isChar(s[2]):
+			return z.tag(htmlEndTag, 2), true
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s[4:], "-->")
+			if end == -1 {
+				z.pos = len(z.s)
+				return htmlToken{typ: htmlComment, text: s[4:]}, true
+			}
+			z.pos += 4 + end + 3
+			return htmlToken{typ: htmlComment, text: s[4 : 4+end]}, true
+		case c == '!' || c == '?' || c == '/':
+			// <!doctype> and other bogus comments
+			end := strings.IndexByte(s, '>')
+			if end == -1 {
+				z.pos = len(z.s)
+				return htmlToken{typ: htmlComment, text: s[2:]}, true
+			}
+			z.pos += end + 1
+			return htmlToken{typ: htmlComment, text: s[2:end]}, true
+		}
+	}
+	// text up to the next tag, a '<' that doesn't start one is text
+	end := 1
+	for end < len(s) {
+		i := strings.IndexByte(s[end:], '<')
+		if i == -1 {
+			end = len(s)
+			break
+		}
+		end += i
+		if end+1 < len(s) && (isChar(s[end+1]) || strings.IndexByte("/!?", s[end+1]) != -1) {
+			break
+		}
+		end += 1
+	}
+	z.pos += end
+	return htmlToken{typ: htmlText, text: unescapeHtml(s[:end])}, true
+}
+
+// tag parses a start or end tag whose name starts at z.pos+skip
+func (z *htmlTokenizer) tag(typ htmlTokenType, skip int) htmlToken {
+	s := z.s
+	i := z.pos + skip
+	start := i
+	for i < len(s) && !isHtmlSpace(s[i]) && s[i] != '/' && s[i] != '>' {
+		i += 1
+	}
+	t := htmlToken{typ: typ, tag: strings.ToLower(s[start:i])}
+	for i < len(s) && s[i] != '>' {
+		if isHtmlSpace(s[i]) || s[i] == '/' {
+			t.selfClosing = s[i] == '/'
+			i += 1
+			continue
+		}
+		t.selfClosing = false
+		start = i
+		for i < len(s) && !isHtmlSpace(s[i]) && s[i] != '/' && s[i] != '>' && (s[i] != '=' || i == start) {
+			i += 1
+		}
+		attr := htmlAttr{name: strings.ToLower(s[start:i]), bare: true}
+		for i < len(s) && isHtmlSpace(s[i]) {
+			i += 1
+		}
+		if i < len(s) && s[i] == '=' {
+			attr.bare = false
+			i += 1
+			for i < len(s) && isHtmlSpace(s[i]) {
+				i += 1
+			}
+			start = i
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end == -1 {
+					end = len(s) - i - 1
+				}
+				attr.value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				for i < len(s) && !isHtmlSpace(s[i]) && s[i] != '>' {
+					i += 1
+				}
+				attr.value = s[start:i]
+			}
+			attr.value = unescapeHtml(attr.value)
+		}
+		if typ == htmlStartTag {
+			t.attrs = append(t.attrs, attr)
+		}
+	}
+	z.pos = i + 1
+	if z.pos > len(s) {
+		z.pos = len(s)
+	}
+	if typ == htmlStartTag && rawTextTags[t.tag] && !t.selfClosing {
+		z.rawTag = t.tag
+	}
+	return t
+}
+
+// rawText returns the content of the element in z.rawTag
+func (z *htmlTokenizer) rawText() htmlToken {
+	s := z.s[z.pos:]
+	end := strings.Index(strings.ToLower(s), "</"+z.rawTag)
+	if end == -1 {
+		end = len(s)
+	}
+	z.pos += end
+	text := s[:end]
+	if z.rawTag == "textarea" || z.rawTag == "title" {
+		text = unescapeHtml(text)
+	}
+	z.rawTag = ""
+	return htmlToken{typ: htmlText, text: text}
+}
+
+// unescapeHtml replaces character references in s, like &amp; and &#8217;,
+// with the characters. Unknown ones are kept as they are.
+func unescapeHtml(s string) string {
+	i := strings.IndexByte(s, '&')
+	if i == -1 {
+		return s
+	}
+	var buf strings.Builder
+	for i != -1 {
+		buf.WriteString(s[:i])
+		s = s[i:]
+		n, r := parseCharRef(s)
+		if n == 0 {
+			buf.WriteByte('&')
+			s = s[1:]
+		} else {
+			buf.WriteString(r)
+			s = s[n:]
+		}
+		i = strings.IndexByte(s, '&')
+	}
+	buf.WriteString(s)
+	return buf.String()
+}
+
+// parseCharRef returns the length and the text of the character reference
+// s starts with, or 0 if it doesn't start with one
+func parseCharRef(s string) (n int, text string) {
+	end := strings.IndexByte(s, ';')
+	if end < 2 || end > 32 {
+		return 0, ""
+	}
+	name := s[1:end]
+	if name[0] != '#' {
+		if r, ok := xml.HTMLEntity[name]; ok {
+			return end + 1, r
+		}
+		return 0, ""
+	}
+	var c uint64
+	var err error
+	if len(name) > 1 && (name[1] == 'x' || name[1] == 'X') {
+		c, err = strconv.ParseUint(name[2:], 16, 32)
+	} else {
+		c, err = strconv.ParseUint(name[1:], 10, 32)
+	}
+	if err != nil || c == 0 || !utf8.ValidRune(rune(c)) {
+		return 0, ""
+	}
+	return end + 1, string(rune(c))
+}
+
+// htmlNode is an element or text of an html tree
+type htmlNode struct {
+	// tag is "" for text
+	tag      string
+	attrs    []htmlAttr
+	text     string
+	children []*htmlNode
+	parent   *htmlNode
+}
+
+func (n *htmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func (n *htmlNode) appendChild(c *htmlNode) {
+	c.parent = n
+	n.children = append(n.children, c)
+}
+
+// elements without content or end tag
+var voidTags = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// closedBy returns true if an open element tag is implicitly closed by the
+// start of an element next, e.g. <li> by another <li>
+func closedBy(tag, next string) bool {
+	switch tag {
+	case "p":
+		return flowTags[next] || next == "pre" || next == "hr"
+	case "li":
+		return next == "li"
+	case "dt", "dd":
+		return next == "dt" || next == "dd"
+	case "tr":
+		return next == "tr"
+	case "td", "th":
+		return next == "td" || next == "th" || next == "tr"
+	}
+	return false
+}
+
+// parseHtmlFragment parses html into a tree whose root is an element with
+// an empty tag. Missing end tags are implied and stray ones are ignored,
+// comments are dropped.
+func parseHtmlFragment(html string) *htmlNode {
+	root := &htmlNode{}
+	cur := root
+	z := &htmlTokenizer{s: html}
+	// a newline right after <pre> isn't a part of its content
+	skipNewline := false
+	for {
+		t, ok := z.next()
+		if !ok {
+			return root
+		}
+		switch t.typ {
+		case htmlText:
+			text := t.text
+			if skipNewline {
+				text = strings.TrimPrefix(strings.TrimPrefix(text, "\r"), "\n")
+			}
+			if text != "" {
+				cur.appendChild(&htmlNode{text: text})
+			}
+		case htmlStartTag:
+			for cur != root && closedBy(cur.tag, t.tag) {
+				cur = cur.parent
+			}
+			n := &htmlNode{tag: t.tag, attrs: t.attrs}
+			cur.appendChild(n)
+			if !voidTags[t.tag] && !t.selfClosing {
+				cur = n
+			}
+		case htmlEndTag:
+			for n := cur; n != root; n = n.parent {
+				if n.tag == t.tag {
+					cur = n.parent
+					break
+				}
+			}
+		}
+		skipNewline = t.typ == htmlStartTag && (t.tag == "pre" || t.tag == "textarea")
+	}
+}
@@ -0,0 +1,75 @@
+package textiler
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"path"
+	"strings"
+
+	// decoders for image sizes
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ImageHook is called for each image of a document. It can change the
+// image, e.g. rewrite Src to point to a CDN or set Loading to "lazy".
+type ImageHook func(img *Image)
+
+// localImagePath returns the path of src in an fs.FS, or "" if src isn't
+// a local file (e.g. has a scheme)
+func localImagePath(src string) string {
+	if src == "" || strings.HasPrefix(src, "//") || strings.Contains(src, ":") {
+		return ""
+	}
+	if i := strings.IndexAny(src, "?#"); i != -1 {
+		src = src[:i]
+	}
+	name := path.Clean(strings.TrimPrefix(src, "/"))
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	return name
+}
+
+// imageSize sets the width and height of img from the header of its file
+// in p.imageRoot
+func (p *TextileParser) imageSize(img *Image) {
+	name := localImagePath(img.Src)
+	if name == "" || img.Width != 0 || img.Height != 0 {
+		return
+	}
+	f, err := p.imageRoot.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			p.report(img.Pos, SeverityWarning, DiagMissingImage, "image %q doesn't exist", img.Src)
+		} else {
+			p.report(img.Pos, SeverityWarning, DiagBadImage, "image %q: %s", img.Src, err)
+		}
+		return
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		p.report(img.Pos, SeverityWarning, DiagBadImage, "image %q: %s", img.Src, err)
+		return
+	}
+	img.Width = cfg.Width
+	img.Height = cfg.Height
+}
+
+// processImages sets sizes of images in n and calls the image hook
+func (p *TextileParser) processImages(n Node) {
+	Inspect(n, func(n Node) bool {
+		if img, ok := n.(*Image); ok {
+			if p.imageRoot != nil {
+				p.imageSize(img)
+			}
+			if p.imageHook != nil {
+				p.imageHook(img)
+			}
+		}
+		return true
+	})
+}
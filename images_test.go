@@ -0,0 +1,49 @@
+package textiler
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+	"testing/fstest"
+)
+
+func pngFile(t *testing.T, w, h int) *fstest.MapFile {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return &fstest.MapFile{Data: buf.Bytes()}
+}
+
+func TestImages(t *testing.T) {
+	root := fstest.MapFS{
+		"img/cat.png": pngFile(t, 40, 30),
+		"img/bad.png": &fstest.MapFile{Data: []byte("not an image")},
+	}
+	opts := &Options{
+		ImageRoot: root,
+		ImageHook: func(img *Image) {
+			if localImagePath(img.Src) != "" {
+				img.Src = "https://cdn.example.com/" + localImagePath(img.Src)
+			}
+			img.Loading = "lazy"
+		},
+	}
+	s := "!/img/cat.png(Cat)! !img/dog.png!\n\n!img/bad.png! !http://a.com/b.png!"
+	got, diags, err := ConvertBytes([]byte(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "\t<p><img src=\"https://cdn.example.com/img/cat.png\" title=\"Cat\" alt=\"Cat\" width=\"40\" height=\"30\" loading=\"lazy\">" +
+		" <img src=\"https://cdn.example.com/img/dog.png\" alt=\"\" loading=\"lazy\"></p>\n\n" +
+		"\t<p><img src=\"https://cdn.example.com/img/bad.png\" alt=\"\" loading=\"lazy\">" +
+		" <img src=\"http://a.com/b.png\" alt=\"\" loading=\"lazy\"></p>"
+	if string(got) != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, string(got))
+	}
+	expDiags := []string{"1:21 missing-image", "3:1 bad-image"}
+	if got := diagCodes(diags); len(got) != 2 || got[0] != expDiags[0] || got[1] != expDiags[1] {
+		t.Fatalf("\nExp:%v\nGot:%v\n", expDiags, got)
+	}
+}
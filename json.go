@@ -0,0 +1,376 @@
+package textiler
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONVersion is the version of the json format of documents, see
+// Document.MarshalJSON. It changes only if the format changes in a way that
+// is not compatible with older readers.
+const JSONVersion = 1
+
+// jsonDocument is a document in json, as described by
+// textiler.schema.json
+type jsonDocument struct {
+	Version     int               `json:"version"`
+	Nodes       []*jsonNode       `json:"nodes"`
+	Refs        map[string]string `json:"refs,omitempty"`
+	Diagnostics []jsonDiagnostic  `json:"diagnostics,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+}
+
+type jsonPos struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+}
+
+type jsonAttrs struct {
+	Class string `json:"class,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Style string `json:"style,omitempty"`
+	Lang  string `json:"lang,omitempty"`
+}
+
+type jsonDiagnostic struct {
+	Pos      *jsonPos `json:"pos,omitempty"`
+	Severity string   `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+}
+
+// jsonNode is a node of any type, with the fields of its type set
+type jsonNode struct {
+	Type         string      `json:"type"`
+	Pos          *jsonPos    `json:"pos,omitempty"`
+	Attrs        *jsonAttrs  `json:"attrs,omitempty"`
+	Text         string      `json:"text,omitempty"`
+	Level        int         `json:"level,omitempty"`
+	Cite         string      `json:"cite,omitempty"`
+	Ordered      bool        `json:"ordered,omitempty"`
+	Preformatted bool        `json:"preformatted,omitempty"`
+	Header       bool        `json:"header,omitempty"`
+	Colspan      int         `json:"colspan,omitempty"`
+	Rowspan      int         `json:"rowspan,omitempty"`
+	ID           string      `json:"id,omitempty"`
+	Tag          string      `json:"tag,omitempty"`
+	Title        string      `json:"title,omitempty"`
+	URL          string      `json:"url,omitempty"`
+	Ref          string      `json:"ref,omitempty"`
+	Wiki         bool        `json:"wiki,omitempty"`
+	Missing      bool        `json:"missing,omitempty"`
+	Src          string      `json:"src,omitempty"`
+	Alt          string      `json:"alt,omitempty"`
+	Width        int         `json:"width,omitempty"`
+	Height       int         `json:"height,omitempty"`
+	Loading      string      `json:"loading,omitempty"`
+	HTML         string      `json:"html,omitempty"`
+	Children     []*jsonNode `json:"children,omitempty"`
+}
+
+// jsonTypes are the names of node types in json
+var jsonTypes = map[string]func() Node{
+	"paragraph":    func() Node { return &Paragraph{} },
+	"heading":      func() Node { return &Heading{} },
+	"blockquote":   func() Node { return &BlockQuote{} },
+	"list":         func() Node { return &List{} },
+	"list_item":    func() Node { return &ListItem{} },
+	"code_block":   func() Node { return &CodeBlock{} },
+	"html_block":   func() Node { return &HTMLBlock{} },
+	"table":        func() Node { return &Table{} },
+	"table_row":    func() Node { return &TableRow{} },
+	"table_cell":   func() Node { return &TableCell{} },
+	"footnote":     func() Node { return &Footnote{} },
+	"text":         func() Node { return &Text{} },
+	"line_break":   func() Node { return &LineBreak{} },
+	"phrase":       func() Node { return &Phrase{} },
+	"span":         func() Node { return &Span{} },
+	"acronym":      func() Node { return &Acronym{} },
+	"code":         func() Node { return &Code{} },
+	"link":         func() Node { return &Link{} },
+	"image":        func() Node { return &Image{} },
+	"raw_html":     func() Node { return &RawHTML{} },
+	"footnote_ref": func() Node { return &FootnoteRef{} },
+}
+
+func toJSONPos(p Pos) *jsonPos {
+	if !p.IsValid() {
+		return nil
+	}
+	return &jsonPos{Offset: p.Offset, Line: p.Line, Col: p.Col}
+}
+
+func (p *jsonPos) pos() Pos {
+	if p == nil {
+		return Pos{}
+	}
+	return Pos{Offset: p.Offset, Line: p.Line, Col: p.Col}
+}
+
+func toJSONAttrs(a Attributes) *jsonAttrs {
+	if a.IsEmpty() {
+		return nil
+	}
+	return &jsonAttrs{Class: a.Class, ID: a.ID, Style: a.Style, Lang: a.Lang}
+}
+
+func (a *jsonAttrs) attrs() Attributes {
+	if a == nil {
+		return Attributes{}
+	}
+	return Attributes{Class: a.Class, ID: a.ID, Style: a.Style, Lang: a.Lang}
+}
+
+// toJSONNode converts n and its children
+func toJSONNode(n Node) *jsonNode {
+	j := &jsonNode{Pos: toJSONPos(n.Position())}
+	switch n := n.(type) {
+	case *Paragraph:
+		j.Type = "paragraph"
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *Heading:
+		j.Type = "heading"
+		j.Level = n.Level
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *BlockQuote:
+		j.Type = "blockquote"
+		j.Attrs = toJSONAttrs(n.Attrs)
+		j.Cite = n.Cite
+	case *List:
+		j.Type = "list"
+		j.Ordered = n.Ordered
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *ListItem:
+		j.Type = "list_item"
+	case *CodeBlock:
+		j.Type = "code_block"
+		j.Text = n.Text
+		j.Attrs = toJSONAttrs(n.Attrs)
+		j.Preformatted = n.Preformatted
+	case *HTMLBlock:
+		j.Type = "html_block"
+	case *Table:
+		j.Type = "table"
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *TableRow:
+		j.Type = "table_row"
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *TableCell:
+		j.Type = "table_cell"
+		j.Attrs = toJSONAttrs(n.Attrs)
+		j.Header = n.Header
+		j.Colspan = n.Colspan
+		j.Rowspan = n.Rowspan
+	case *Footnote:
+		j.Type = "footnote"
+		j.ID = n.ID
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *Text:
+		j.Type = "text"
+		j.Text = n.Text
+	case *LineBreak:
+		j.Type = "line_break"
+	case *Phrase:
+		j.Type = "phrase"
+		j.Tag = n.Tag
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *Span:
+		j.Type = "span"
+		j.Attrs = toJSONAttrs(n.Attrs)
+	case *Acronym:
+		j.Type = "acronym"
+		j.Text = n.Text
+		j.Title = n.Title
+	case *Code:
+		j.Type = "code"
+		j.Text = n.Text
+	case *Link:
+		j.Type = "link"
+		j.URL = n.URL
+		j.Ref = n.Ref
+		j.Wiki = n.Wiki
+		j.Missing = n.Missing
+	case *Image:
+		j.Type = "image"
+		j.Src = n.Src
+		j.Alt = n.Alt
+		j.Attrs = toJSONAttrs(n.Attrs)
+		j.URL = n.URL
+		j.Width = n.Width
+		j.Height = n.Height
+		j.Loading = n.Loading
+	case *RawHTML:
+		j.Type = "raw_html"
+		j.HTML = n.HTML
+	case *FootnoteRef:
+		j.Type = "footnote_ref"
+		j.ID = n.ID
+	default:
+		panic(fmt.Sprintf("textiler: unknown node type %T", n))
+	}
+	for _, c := range n.Children() {
+		j.Children = append(j.Children, toJSONNode(c))
+	}
+	return j
+}
+
+// node converts j and its children
+func (j *jsonNode) node() (Node, error) {
+	if j == nil {
+		return nil, fmt.Errorf("textiler: null node")
+	}
+	newNode, ok := jsonTypes[j.Type]
+	if !ok {
+		return nil, fmt.Errorf("textiler: unknown node type %q", j.Type)
+	}
+	n := newNode()
+	pos := j.Pos.pos()
+	attrs := j.Attrs.attrs()
+	switch n := n.(type) {
+	case *Paragraph:
+		n.Attrs = attrs
+	case *Heading:
+		if j.Level < 1 || j.Level > 6 {
+			return nil, fmt.Errorf("textiler: invalid heading level %d", j.Level)
+		}
+		n.Level = j.Level
+		n.Attrs = attrs
+	case *BlockQuote:
+		n.Attrs = attrs
+		n.Cite = j.Cite
+	case *List:
+		n.Ordered = j.Ordered
+		n.Attrs = attrs
+	case *CodeBlock:
+		n.Pos = pos
+		n.Text = j.Text
+		n.Attrs = attrs
+		n.Preformatted = j.Preformatted
+	case *Table:
+		n.Attrs = attrs
+	case *TableRow:
+		n.Attrs = attrs
+	case *TableCell:
+		n.Attrs = attrs
+		n.Header = j.Header
+		n.Colspan = j.Colspan
+		n.Rowspan = j.Rowspan
+	case *Footnote:
+		n.ID = j.ID
+		n.Attrs = attrs
+	case *Text:
+		n.Pos = pos
+		n.Text = j.Text
+	case *LineBreak:
+		n.Pos = pos
+	case *Phrase:
+		n.Tag = j.Tag
+		n.Attrs = attrs
+	case *Span:
+		n.Attrs = attrs
+	case *Acronym:
+		n.Pos = pos
+		n.Text = j.Text
+		n.Title = j.Title
+	case *Code:
+		n.Pos = pos
+		n.Text = j.Text
+	case *Link:
+		n.URL = j.URL
+		n.Ref = j.Ref
+		n.Wiki = j.Wiki
+		n.Missing = j.Missing
+	case *Image:
+		n.Pos = pos
+		n.Src = j.Src
+		n.Alt = j.Alt
+		n.Attrs = attrs
+		n.URL = j.URL
+		n.Width = j.Width
+		n.Height = j.Height
+		n.Loading = j.Loading
+	case *RawHTML:
+		n.Pos = pos
+		n.HTML = j.HTML
+	case *FootnoteRef:
+		n.Pos = pos
+		n.ID = j.ID
+	}
+	if c, ok := n.(interface{ container() *Container }); ok {
+		c := c.container()
+		c.Pos = pos
+		for _, child := range j.Children {
+			cn, err := child.node()
+			if err != nil {
+				return nil, err
+			}
+			c.Nodes = append(c.Nodes, cn)
+		}
+	} else if len(j.Children) > 0 {
+		return nil, fmt.Errorf("textiler: %s node has children", j.Type)
+	}
+	return n, nil
+}
+
+func (c *Container) container() *Container {
+	return c
+}
+
+// MarshalJSON encodes the document as json, described by the json schema
+// in textiler.schema.json: its version, the tree of nodes with their
+// types, attributes, text, urls and positions in the source, the link
+// references, the diagnostics and the metadata.
+func (doc *Document) MarshalJSON() ([]byte, error) {
+	j := &jsonDocument{Version: JSONVersion, Nodes: []*jsonNode{}, Refs: doc.Refs, Meta: doc.Meta}
+	for _, n := range doc.Nodes {
+		j.Nodes = append(j.Nodes, toJSONNode(n))
+	}
+	for _, d := range doc.Diagnostics {
+		j.Diagnostics = append(j.Diagnostics, jsonDiagnostic{
+			Pos:      toJSONPos(d.Pos),
+			Severity: d.Severity.String(),
+			Code:     d.Code,
+			Message:  d.Message,
+		})
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a document encoded by MarshalJSON. It fails for
+// documents of newer versions of the format and unknown node types.
+func (doc *Document) UnmarshalJSON(data []byte) error {
+	var j jsonDocument
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Version < 1 || j.Version > JSONVersion {
+		return fmt.Errorf("textiler: unsupported json version %d", j.Version)
+	}
+	d := Document{Refs: j.Refs, Meta: j.Meta}
+	for _, jn := range j.Nodes {
+		n, err := jn.node()
+		if err != nil {
+			return err
+		}
+		d.Nodes = append(d.Nodes, n)
+	}
+	for _, jd := range j.Diagnostics {
+		severity := SeverityWarning
+		switch jd.Severity {
+		case "warning":
+		case "error":
+			severity = SeverityError
+		default:
+			return fmt.Errorf("textiler: unknown severity %q", jd.Severity)
+		}
+		d.Diagnostics = append(d.Diagnostics, Diagnostic{
+			Pos:      jd.Pos.pos(),
+			Severity: severity,
+			Code:     jd.Code,
+			Message:  jd.Message,
+		})
+	}
+	*doc = d
+	return nil
+}
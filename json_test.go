@@ -0,0 +1,136 @@
+package textiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	for i := 0; i < len(corpus); i += 2 {
+		doc := Parse([]byte(corpus[i]))
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Document
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s\n\n%s", err, data)
+		}
+		if exp, got := Render(doc, NewHtmlRenderer()), Render(&decoded, NewHtmlRenderer()); !bytes.Equal(got, exp) {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", corpus[i], string(exp), string(got))
+		}
+		again, err := json.Marshal(&decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Fatalf("\nSrc:%#v\n\nExp:%s\n\nGot:%s\n", corpus[i], data, again)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	doc := Parse([]byte("h2(#top). *Hi* \"there\":x\n\n[x]http://x.com"))
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"version":1,"nodes":[{"type":"heading","pos":{"offset":0,"line":1,"col":1},"attrs":{"id":"top"},"level":2,"children":[` +
+		`{"type":"phrase","pos":{"offset":10,"line":1,"col":11},"tag":"strong","children":[{"type":"text","pos":{"offset":11,"line":1,"col":12},"text":"Hi"}]},` +
+		`{"type":"text","pos":{"offset":14,"line":1,"col":15},"text":" "},` +
+		`{"type":"link","pos":{"offset":15,"line":1,"col":16},"url":"http://x.com","ref":"x","children":[{"type":"text","pos":{"offset":16,"line":1,"col":17},"text":"there"}]}]}],` +
+		`"refs":{"x":"http://x.com"}}`
+	if string(data) != exp {
+		t.Fatalf("\nExp:%s\n\nGot:%s\n", exp, data)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []string{
+		`{"version":2,"nodes":[]}`,
+		`{"nodes":[]}`,
+		`{"version":1,"nodes":[{"type":"marquee"}]}`,
+		`{"version":1,"nodes":[null]}`,
+		`{"version":1,"nodes":[{"type":"heading","level":7}]}`,
+		`{"version":1,"nodes":[{"type":"text","children":[{"type":"text"}]}]}`,
+		`{"version":1,"nodes":[],"diagnostics":[{"severity":"fatal","code":"x","message":"x"}]}`,
+	}
+	for _, s := range tests {
+		var doc Document
+		if err := json.Unmarshal([]byte(s), &doc); err == nil {
+			t.Fatalf("%s: no error", s)
+		}
+	}
+}
+
+// TestJSONSchema checks that the schema describes the node types and the
+// fields of nodes in the corpus
+func TestJSONSchema(t *testing.T) {
+	data, err := os.ReadFile("textiler.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]json.RawMessage
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	var types struct {
+		Enum []string
+	}
+	if err := json.Unmarshal(schema.Defs["node"].Properties["type"], &types); err != nil {
+		t.Fatal(err)
+	}
+	var exp []string
+	for typ := range jsonTypes {
+		exp = append(exp, typ)
+	}
+	sort.Strings(exp)
+	sort.Strings(types.Enum)
+	if strings.Join(types.Enum, " ") != strings.Join(exp, " ") {
+		t.Fatalf("\nExp:%v\n\nGot:%v\n", exp, types.Enum)
+	}
+
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	var check func(n map[string]interface{})
+	check = func(n map[string]interface{}) {
+		typ := n["type"].(string)
+		def, ok := schema.Defs[typ]
+		if !ok {
+			t.Fatalf("no definition of %s", typ)
+		}
+		for field, v := range n {
+			if _, ok := def.Properties[field]; !ok {
+				t.Fatalf("%s has no field %s", typ, field)
+			}
+			if field == "children" {
+				for _, c := range v.([]interface{}) {
+					check(c.(map[string]interface{}))
+				}
+			}
+		}
+	}
+	for i := 0; i < len(corpus); i += 2 {
+		data, err := json.Marshal(Parse([]byte(corpus[i])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Nodes []map[string]interface{}
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range doc.Nodes {
+			check(n)
+		}
+	}
+}
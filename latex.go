@@ -0,0 +1,560 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultLatexPreamble is the preamble of standalone LaTeX documents, with
+// the packages LatexRenderer uses
+const DefaultLatexPreamble = `\documentclass{article}
+\usepackage[utf8]{inputenc}
+\usepackage[T1]{fontenc}
+\usepackage{graphicx}
+\usepackage{listings}
+\usepackage{multirow}
+\usepackage[normalem]{ulem}
+\usepackage{hyperref}`
+
+// LatexRenderer renders LaTeX: headings as \section etc., lists as itemize
+// and enumerate, bc. as lstlisting, pre. as verbatim, tables as tabular and
+// footnotes as \footnote at their first reference. Html is reduced to its
+// text. The output is a fragment, or a complete document if standalone is
+// set.
+type LatexRenderer struct {
+	standalone bool
+	preamble   string
+	// a block was rendered, the next one is separated from it
+	separate bool
+	// footnotes by their ids and the ones referenced so far
+	footnotes    map[string]string
+	footnoteRefs map[string]bool
+	// number of placeholders in out of footnotes that aren't rendered yet
+	placeholders int
+	// offset in out where the footnote being rendered starts
+	footnoteStart int
+	table         *latexTable
+	// depth of nested lists
+	lists int
+	// offset in out where the html block being rendered starts and if it's
+	// separated from the previous block
+	htmlStart    int
+	htmlSeparate bool
+	// depth of nodes with inline content, e.g. paragraphs
+	inline int
+}
+
+type latexTable struct {
+	rows [][]latexCell
+	cols int
+	// number of rows below the current one that cells span, by column
+	spans []int
+	// offset in out where the current cell starts
+	start int
+}
+
+type latexCell struct {
+	text string
+	cols int
+}
+
+func NewLatexRenderer() *LatexRenderer {
+	return &LatexRenderer{
+		preamble:     DefaultLatexPreamble,
+		footnotes:    make(map[string]string),
+		footnoteRefs: make(map[string]bool),
+	}
+}
+
+// RenderLatex renders a document as a LaTeX fragment
+func RenderLatex(doc *Document) []byte {
+	return Render(doc, NewLatexRenderer())
+}
+
+// escapeLatex escapes characters that are special in LaTeX
+func escapeLatex(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\\':
+			buf.WriteString(`\textbackslash{}`)
+		case '~':
+			buf.WriteString(`\textasciitilde{}`)
+		case '^':
+			buf.WriteString(`\textasciicircum{}`)
+		case '<':
+			buf.WriteString(`\textless{}`)
+		case '>':
+			buf.WriteString(`\textgreater{}`)
+		case '|':
+			buf.WriteString(`\textbar{}`)
+		case '{', '}', '$', '&', '#', '%', '_':
+			buf.WriteByte('\\')
+			buf.WriteRune(c)
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// escapeLatexUrl escapes characters of a url that are special in the
+// argument of \href
+func escapeLatexUrl(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\\', '{', '}', '#', '%':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// footnotePlaceholder is written for references to footnotes that aren't
+// rendered yet, which are replaced once they are
+func footnotePlaceholder(id string) string {
+	return "\x00" + id + "\x00"
+}
+
+// footnoteRef returns the reference to the footnote id with text, the
+// footnote itself for the first one and its number for the others
+func (r *LatexRenderer) footnoteRef(id, text string) string {
+	if r.footnoteRefs[id] {
+		return `\textsuperscript{\ref{fn:` + escapeLatex(id) + `}}`
+	}
+	r.footnoteRefs[id] = true
+	return `\footnote{\label{fn:` + escapeLatex(id) + `}` + text + `}`
+}
+
+// resolveFootnotes replaces placeholders in out with references to the
+// footnotes rendered so far. If final is true, the ones of footnotes that
+// don't exist are replaced with their ids.
+func (r *LatexRenderer) resolveFootnotes(out *bytes.Buffer, final bool) {
+	if r.placeholders == 0 {
+		return
+	}
+	r.placeholders = 0
+	parts := strings.Split(out.String(), "\x00")
+	for i := 1; i < len(parts); i += 2 {
+		id := parts[i]
+		text, ok := r.footnotes[id]
+		switch {
+		case ok:
+			parts[i] = r.footnoteRef(id, text)
+		case final:
+			parts[i] = `\textsuperscript{` + escapeLatex(id) + `}`
+		default:
+			parts[i] = footnotePlaceholder(id)
+			r.placeholders++
+		}
+	}
+	out.Reset()
+	out.WriteString(strings.Join(parts, ""))
+}
+
+// holding returns true while out has references to footnotes that aren't
+// rendered yet
+func (r *LatexRenderer) holding() bool {
+	return r.placeholders > 0
+}
+
+// startBlock separates a block from the previous one
+func (r *LatexRenderer) startBlock(out *bytes.Buffer) {
+	if r.separate {
+		out.WriteString("\n\n")
+	}
+	r.separate = false
+}
+
+func (r *LatexRenderer) endBlock() {
+	r.separate = true
+}
+
+func (r *LatexRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.separate = false
+	r.footnotes = make(map[string]string)
+	r.footnoteRefs = make(map[string]bool)
+	r.placeholders = 0
+	r.table = nil
+	r.lists = 0
+	r.inline = 0
+	if r.standalone {
+		out.WriteString(r.preamble + "\n\n\\begin{document}")
+		r.separate = true
+	}
+}
+
+// DocumentFooter replaces references to footnotes that don't exist with
+// their ids
+func (r *LatexRenderer) DocumentFooter(out *bytes.Buffer) {
+	if r.standalone {
+		out.WriteString("\n\n\\end{document}\n")
+	}
+	r.resolveFootnotes(out, true)
+}
+
+// BlockSeparator writes nothing, blocks are separated when they start
+// because footnotes are rendered where they're referenced
+func (r *LatexRenderer) BlockSeparator(out *bytes.Buffer) {
+}
+
+// latexAlignments are environments of aligned paragraphs
+var latexAlignments = []struct {
+	style, env string
+}{
+	{"text-align:center;", "center"},
+	{"text-align:right;", "flushright"},
+	{"text-align:left;", "flushleft"},
+}
+
+// alignment returns the environment of a paragraph with attrs, or ""
+func alignment(attrs Attributes) string {
+	style := normalizeStyle(attrs.Style)
+	for _, a := range latexAlignments {
+		if strings.Contains(style, a.style) {
+			return a.env
+		}
+	}
+	return ""
+}
+
+func (r *LatexRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	env := alignment(n.Attrs)
+	if entering {
+		r.inline++
+		r.startBlock(out)
+		if env != "" {
+			out.WriteString(`\begin{` + env + "}\n")
+		}
+		return
+	}
+	if env != "" {
+		out.WriteString("\n\\end{" + env + "}")
+	}
+	r.inline--
+	r.endBlock()
+}
+
+var latexSections = []string{"section", "subsection", "subsubsection", "paragraph", "subparagraph", "subparagraph"}
+
+func (r *LatexRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		r.inline++
+		r.startBlock(out)
+		out.WriteString(`\` + latexSections[n.Level-1] + "{")
+		return
+	}
+	r.inline--
+	out.WriteString("}")
+	if n.Attrs.ID != "" {
+		out.WriteString(`\label{` + escapeLatex(n.Attrs.ID) + "}")
+	}
+	r.endBlock()
+}
+
+func (r *LatexRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	if entering {
+		r.startBlock(out)
+		out.WriteString("\\begin{quote}\n")
+		return
+	}
+	if n.Cite != "" {
+		out.WriteString(`\\` + "\n" + `\hfill\url{` + escapeLatexUrl(n.Cite) + "}")
+	}
+	out.WriteString("\n\\end{quote}")
+	r.endBlock()
+}
+
+func listEnv(n *List) string {
+	if n.Ordered {
+		return "enumerate"
+	}
+	return "itemize"
+}
+
+func (r *LatexRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if entering {
+		if r.lists > 0 {
+			out.WriteString("\n")
+		} else {
+			r.startBlock(out)
+		}
+		r.lists++
+		out.WriteString(`\begin{` + listEnv(n) + "}")
+		return
+	}
+	r.lists--
+	out.WriteString("\n\\end{" + listEnv(n) + "}")
+	r.endBlock()
+}
+
+func (r *LatexRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	if entering {
+		r.inline++
+		out.WriteString("\n\\item ")
+		r.separate = false
+	} else {
+		r.inline--
+	}
+}
+
+func (r *LatexRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	r.startBlock(out)
+	env := "lstlisting"
+	if n.Preformatted {
+		env = "verbatim"
+	}
+	code := strings.TrimRight(n.Text, "\n")
+	if strings.Contains(code, `\end{`+env+`}`) {
+		// it would end the environment early
+		out.WriteString(codeLines(code))
+	} else {
+		out.WriteString(fmt.Sprintf("\\begin{%s}\n%s\n\\end{%s}", env, code, env))
+	}
+	r.endBlock()
+}
+
+// codeLines renders code as escaped lines of \texttt, for code that can't
+// be in a verbatim environment
+func codeLines(code string) string {
+	lines := strings.Split(code, "\n")
+	for i, l := range lines {
+		lines[i] = `\texttt{` + strings.Replace(escapeLatex(l), " ", "~", -1) + `}`
+	}
+	return "\\noindent\n" + strings.Join(lines, "\\\\\n")
+}
+
+// HTMLBlock renders the text of html, without the newlines around it,
+// nothing if there's no text
+func (r *LatexRenderer) HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool) {
+	if entering {
+		r.htmlStart = out.Len()
+		r.htmlSeparate = r.separate
+		return
+	}
+	text := strings.Trim(string(out.Bytes()[r.htmlStart:]), "\n")
+	out.Truncate(r.htmlStart)
+	r.separate = r.htmlSeparate
+	if text != "" {
+		r.startBlock(out)
+		out.WriteString(text)
+		r.endBlock()
+	}
+}
+
+func (r *LatexRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if entering {
+		r.startBlock(out)
+		r.table = &latexTable{}
+		return
+	}
+	t := r.table
+	r.table = nil
+	out.WriteString(t.String())
+	r.endBlock()
+}
+
+func (r *LatexRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	t := r.table
+	if entering {
+		t.rows = append(t.rows, nil)
+		return
+	}
+	for t.spanned() {
+	}
+}
+
+// spanned adds an empty cell to the current row if its next column is
+// spanned by a cell of an earlier row
+func (t *latexTable) spanned() bool {
+	row := &t.rows[len(t.rows)-1]
+	col := 0
+	for _, c := range *row {
+		col += c.cols
+	}
+	if col >= len(t.spans) || t.spans[col] == 0 {
+		return false
+	}
+	t.spans[col] -= 1
+	*row = append(*row, latexCell{cols: 1})
+	return true
+}
+
+func (r *LatexRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	t := r.table
+	if entering {
+		r.inline++
+		for t.spanned() {
+		}
+		t.start = out.Len()
+		return
+	}
+	r.inline--
+	text := strings.TrimSpace(string(out.Bytes()[t.start:]))
+	out.Truncate(t.start)
+	if n.Header {
+		text = `\textbf{` + text + "}"
+	}
+	cell := latexCell{text: text, cols: 1}
+	if n.Colspan > 1 {
+		cell.cols = n.Colspan
+	}
+	row := &t.rows[len(t.rows)-1]
+	col := 0
+	for _, c := range *row {
+		col += c.cols
+	}
+	for len(t.spans) < col+cell.cols {
+		t.spans = append(t.spans, 0)
+	}
+	if n.Rowspan > 1 {
+		for i := col; i < col+cell.cols; i++ {
+			t.spans[i] = n.Rowspan - 1
+		}
+		cell.text = fmt.Sprintf(`\multirow{%d}{*}{%s}`, n.Rowspan, cell.text)
+	}
+	if cell.cols > 1 {
+		cell.text = fmt.Sprintf(`\multicolumn{%d}{|l|}{%s}`, cell.cols, cell.text)
+	}
+	*row = append(*row, cell)
+	if col+cell.cols > t.cols {
+		t.cols = col + cell.cols
+	}
+}
+
+// String returns t as a tabular with left aligned columns and lines
+// between the cells
+func (t *latexTable) String() string {
+	var buf strings.Builder
+	buf.WriteString(`\begin{tabular}{|` + strings.Repeat("l|", t.cols) + "}\n\\hline")
+	for _, row := range t.rows {
+		var cells []string
+		cols := 0
+		for _, c := range row {
+			cells = append(cells, c.text)
+			cols += c.cols
+		}
+		for ; cols < t.cols; cols++ {
+			cells = append(cells, "")
+		}
+		buf.WriteString("\n" + strings.Join(cells, " & ") + ` \\ \hline`)
+	}
+	buf.WriteString("\n\\end{tabular}")
+	return buf.String()
+}
+
+// Footnote renders the text of a footnote, which is put where it's
+// referenced
+func (r *LatexRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	if entering {
+		r.inline++
+		r.footnoteStart = out.Len()
+		return
+	}
+	r.inline--
+	r.footnotes[n.ID] = strings.TrimSpace(string(out.Bytes()[r.footnoteStart:]))
+	out.Truncate(r.footnoteStart)
+	r.resolveFootnotes(out, false)
+}
+
+func (r *LatexRenderer) Text(out *bytes.Buffer, n *Text) {
+	out.WriteString(escapeLatex(unescapeHtml(n.Text)))
+}
+
+func (r *LatexRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	if r.table != nil {
+		out.WriteString(" ")
+		return
+	}
+	out.WriteString("\\\\\n")
+}
+
+// latexPhrases are the commands of phrases
+var latexPhrases = map[string]string{
+	"em":     `\emph`,
+	"strong": `\textbf`,
+	"i":      `\textit`,
+	"b":      `\textbf`,
+	"cite":   `\emph`,
+	"del":    `\sout`,
+	"ins":    `\uline`,
+	"sup":    `\textsuperscript`,
+	"sub":    `\textsubscript`,
+}
+
+func (r *LatexRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	if entering {
+		out.WriteString(latexPhrases[n.Tag] + "{")
+	} else {
+		out.WriteString("}")
+	}
+}
+
+func (r *LatexRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+}
+
+func (r *LatexRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	out.WriteString(`\texttt{` + escapeLatex(n.Text) + "}")
+}
+
+func (r *LatexRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	out.WriteString(escapeLatex(fmt.Sprintf("%s (%s)", n.Text, n.Title)))
+}
+
+func (r *LatexRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if n.Missing {
+		return
+	}
+	if entering {
+		out.WriteString(`\href{` + escapeLatexUrl(n.URL) + "}{")
+	} else {
+		out.WriteString("}")
+	}
+}
+
+func (r *LatexRenderer) Image(out *bytes.Buffer, n *Image) {
+	var opts []string
+	if n.Width > 0 {
+		opts = append(opts, fmt.Sprintf("width=%dpx", n.Width))
+	}
+	if n.Height > 0 {
+		opts = append(opts, fmt.Sprintf("height=%dpx", n.Height))
+	}
+	img := `\includegraphics`
+	if len(opts) > 0 {
+		img += "[" + strings.Join(opts, ",") + "]"
+	}
+	img += "{" + escapeLatexUrl(n.Src) + "}"
+	if n.URL != "" {
+		img = `\href{` + escapeLatexUrl(n.URL) + "}{" + img + "}"
+	}
+	out.WriteString(img)
+}
+
+// RawHTML renders the text of html, as a block if it's not inside of one
+func (r *LatexRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	s := escapeLatex(textOfHtml(n.HTML))
+	if r.inline > 0 {
+		out.WriteString(s)
+		return
+	}
+	if s = strings.TrimSpace(s); s != "" {
+		r.startBlock(out)
+		out.WriteString(s)
+		r.endBlock()
+	}
+}
+
+// FootnoteRef writes the reference if the footnote was rendered already,
+// otherwise a placeholder for it
+func (r *LatexRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	if text, ok := r.footnotes[n.ID]; ok {
+		out.WriteString(r.footnoteRef(n.ID, text))
+		return
+	}
+	out.WriteString(footnotePlaceholder(n.ID))
+	r.placeholders++
+}
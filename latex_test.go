@@ -0,0 +1,72 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestLatexCorpus(t *testing.T) {
+	checkGolden(t, "latex.golden", renderCorpus(t, &Options{Flavor: FlavorLatex, Glyphs: true}))
+}
+
+func TestLatex(t *testing.T) {
+	tests := []string{
+		"h1(#intro). Title\n\nh2. Sub\n\np=. Some *strong* and _em_ text with \"a link\":http://x.com/a%20b#c",
+		"\\section{Title}\\label{intro}\n\n\\subsection{Sub}\n\n\\begin{center}\nSome \\textbf{strong} and \\emph{em} text with \\href{http://x.com/a\\%20b\\#c}{a link}\n\\end{center}",
+
+		"Specials: \\ { } $ & # % _ ~ ^ < > | and @a_b{}<|>@",
+		"Specials: \\textbackslash{} \\{ \\} \\$ \\& \\# \\% \\_ \\textasciitilde{} \\textasciicircum{} \\textless{} \\textgreater{} \\textbar{} and \\texttt{a\\_b\\{\\}\\textless{}\\textbar{}\\textgreater{}}",
+
+		"* one\n** two\n* three\n\n# a\n# b",
+		"\\begin{itemize}\n\\item one\n\\begin{itemize}\n\\item two\n\\end{itemize}\n\\item three\n\\end{itemize}\n\n\\begin{enumerate}\n\\item a\n\\item b\n\\end{enumerate}",
+
+		"bc. if a < b {\n  return\n}\n\npre. pre\n  formatted",
+		"\\begin{lstlisting}\nif a < b {\n  return\n}\n\\end{lstlisting}\n\n\\begin{verbatim}\npre\n  formatted\n\\end{verbatim}",
+
+		// code that would end its environment
+		"bc. x\n\\end{lstlisting}\n\\input{/etc/passwd}\n\npre. \\end{verbatim}  y",
+		"\\noindent\n\\texttt{x}\\\\\n\\texttt{\\textbackslash{}end\\{lstlisting\\}}\\\\\n\\texttt{\\textbackslash{}input\\{/etc/passwd\\}}\n\n" +
+			"\\noindent\n\\texttt{\\textbackslash{}end\\{verbatim\\}~~y}",
+
+		"A note[1] and again[1] and a missing one[2].\n\nfn1. The *note*.",
+		"A note\\footnote{\\label{fn:1}The \\textbf{note}.} and again\\textsuperscript{\\ref{fn:1}} and a missing one\\textsuperscript{2}.",
+
+		"|_. name|_. value|\n|a|1|\n|\\2. spanning|\n|/2. x|y|\n|z|",
+		"\\begin{tabular}{|l|l|}\n\\hline\n\\textbf{name} & \\textbf{value} \\\\ \\hline\n" +
+			"a & 1 \\\\ \\hline\n\\multicolumn{2}{|l|}{spanning} \\\\ \\hline\n" +
+			"\\multirow{2}{*}{x} & y \\\\ \\hline\n & z \\\\ \\hline\n\\end{tabular}",
+
+		"!img.png(alt)!:http://i.com and -del- +ins+ line\nbreak",
+		"\\href{http://i.com}{\\includegraphics{img.png}} and \\sout{del} \\uline{ins} line\\\\\nbreak",
+
+		"bq.:http://c.com quote\n\nnotextile. <p>raw <b>html</b> & more</p>",
+		"\\begin{quote}\nquote\\\\\n\\hfill\\url{http://c.com}\n\\end{quote}\n\nraw html \\& more",
+
+		"Before.\n\n<div>\n\nInside.\n\n</div>\n\nAfter.",
+		"Before.\n\nInside.\n\nAfter.",
+	}
+	opts := &Options{Flavor: FlavorLatex}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
+func TestLatexConvert(t *testing.T) {
+	checkConvertMatches(t, &Options{Flavor: FlavorLatex},
+		"see[1]\n\nfn1. note",
+		"fn1. note\n\nsee[1] and[1]\n\nmissing[2]",
+		"see[1]\n\nand[2]\n\nfn2. two\n\nagain[1] and[2]\n\nfn1. one")
+}
+
+func TestLatexStandalone(t *testing.T) {
+	got := convertBytes(t, "Hello.", &Options{Flavor: FlavorLatex, Standalone: true})
+	if exp := DefaultLatexPreamble + "\n\n\\begin{document}\n\nHello.\n\n\\end{document}\n"; got != exp {
+		t.Fatalf("\nExp:%#v\n\nGot:%#v\n", exp, got)
+	}
+	opts := &Options{Flavor: FlavorLatex, Standalone: true, Preamble: "\\documentclass{book}"}
+	got = convertBytes(t, "Hello.", opts)
+	if exp := "\\documentclass{book}\n\n\\begin{document}\n\nHello.\n\n\\end{document}\n"; got != exp {
+		t.Fatalf("\nExp:%#v\n\nGot:%#v\n", exp, got)
+	}
+}
@@ -0,0 +1,123 @@
+package textiler
+
+import "bytes"
+
+// Limits bound the amount of work the parser does on a document, so that
+// rendering untrusted input takes time and stack proportional to its size.
+// When a limit is reached, the affected text is serialized as escaped text
+// instead of being parsed as textile.
+//
+// MaxLineSize and MaxPendingBlocks also bound the memory used by Convert.
+//
+// A zero field means the corresponding value from DefaultLimits, a negative
+// one disables the limit.
+type Limits struct {
+	// MaxInputSize is the maximum size of the input, in bytes, that is
+	// parsed as textile. Larger inputs are serialized as escaped paragraphs.
+	MaxInputSize int
+	// MaxNestingDepth is the maximum nesting depth of inline markup
+	// (e.g. a span inside emphasis inside a link).
+	MaxNestingDepth int
+	// MaxLineWork is the maximum number of bytes the inline parser scans,
+	// per line, while looking for the end of inline markup. The rest of the
+	// line is serialized as escaped text.
+	MaxLineWork int
+	// MaxLineSize is the maximum length of a line, in bytes. A longer line
+	// is serialized as escaped paragraphs of at most that many bytes, and
+	// so is the rest of the input.
+	MaxLineSize int
+	// MaxPendingBlocks is the maximum number of blocks Convert holds back
+	// while waiting for definitions of references they use.
+	MaxPendingBlocks int
+}
+
+// DefaultLimits are the limits used by parsers unless set with SetLimits
+var DefaultLimits = Limits{
+	MaxInputSize:    16 << 20,
+	MaxNestingDepth: 64,
+	MaxLineWork:     1 << 20,
+	MaxLineSize:     1 << 20,
+
+	MaxPendingBlocks: 1024,
+}
+
+func limitOrDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (l Limits) normalize() Limits {
+	return Limits{
+		MaxInputSize:    limitOrDefault(l.MaxInputSize, DefaultLimits.MaxInputSize),
+		MaxNestingDepth: limitOrDefault(l.MaxNestingDepth, DefaultLimits.MaxNestingDepth),
+		MaxLineWork:     limitOrDefault(l.MaxLineWork, DefaultLimits.MaxLineWork),
+		MaxLineSize:     limitOrDefault(l.MaxLineSize, DefaultLimits.MaxLineSize),
+
+		MaxPendingBlocks: limitOrDefault(l.MaxPendingBlocks, DefaultLimits.MaxPendingBlocks),
+	}
+}
+
+// SetLimits sets limits used by the parser. See Limits for the meaning of
+// zero and negative values.
+func (p *TextileParser) SetLimits(limits Limits) {
+	p.limits = limits.normalize()
+}
+
+// isInlineStart returns true if b can start inline markup
+func isInlineStart(b byte) bool {
+	switch b {
+	case '-', '+', '^', '~', '?', '_', '*', '"', '!', '@', '%', '(', '<':
+		return true
+	}
+	return false
+}
+
+// scanCost returns the number of bytes scanned when looking for c in l
+func scanCost(l []byte, c byte) int {
+	if idx := bytes.IndexByte(l, c); idx != -1 {
+		return idx + 1
+	}
+	return len(l)
+}
+
+// attrsScanCost returns at most the number of bytes parseAttributesOpt
+// scans in l while looking for the ends of ($class), [$lang] and {$style}
+func attrsScanCost(l []byte) int {
+	cost := 0
+	for len(l) > 0 {
+		var end byte
+		switch l[0] {
+		case '<', '>', '=':
+			l = l[1:]
+			continue
+		case '(':
+			end = ')'
+		case '[':
+			end = ']'
+		case '{':
+			end = '}'
+		default:
+			return cost
+		}
+		idx := bytes.IndexByte(l, end)
+		if idx == -1 {
+			return cost + len(l)
+		}
+		cost += idx + 1
+		l = l[idx+1:]
+	}
+	return cost
+}
+
+func (p *TextileParser) spend(n int) {
+	p.work += n
+}
+
+func (p *TextileParser) overBudget() bool {
+	return p.limits.MaxLineWork > 0 && p.work > p.limits.MaxLineWork
+}
@@ -0,0 +1,91 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimitsNesting(t *testing.T) {
+	p := NewParser(0)
+	p.SetLimits(Limits{MaxNestingDepth: 2})
+	got := string(p.ToHtml([]byte("*a _b -c- d_ e*")))
+	exp := "\t<p><strong>a <em>b -c- d</em> e</strong></p>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestLimitsLineWork(t *testing.T) {
+	p := NewParser(0)
+	p.SetLimits(Limits{MaxLineWork: 8})
+	got := string(p.ToHtml([]byte("*a* and _a long tail of text_ _b_ <x>")))
+	exp := "\t<p><strong>a</strong> and <em>a long tail of text</em> _b_ &lt;x&gt;</p>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestLimitsInputSize(t *testing.T) {
+	p := NewParser(0)
+	p.SetLimits(Limits{MaxInputSize: 10})
+	got := string(p.ToHtml([]byte("h1. *not* parsed\n\nbq. <b>")))
+	exp := "\t<p>h1. *not* parsed</p>\n\n\t<p>bq. &lt;b&gt;</p>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestLimitsLineSize(t *testing.T) {
+	p := NewParser(0)
+	p.SetLimits(Limits{MaxLineSize: 8})
+	got := string(p.ToHtml([]byte("h1. *a*\n\n*bold* and é\n\nh2. b")))
+	exp := "\t<h1><strong>a</strong></h1>\n\n\t<p>*bold* a</p>\n\n\t<p>nd é</p>\n\n\t<p>h2. b</p>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+	doc := p.Parse([]byte("a\n0123456789"))
+	if len(doc.Diagnostics) != 1 || doc.Diagnostics[0].Code != DiagLineTooLong || doc.Diagnostics[0].Pos.Line != 2 {
+		t.Fatalf("unexpected diagnostics %v", doc.Diagnostics)
+	}
+}
+
+var adversarialInputs = []struct {
+	name   string
+	repeat string
+}{
+	{"emphasis", "*_"},
+	{"spans", "%%a"},
+	{"open-tags", "<<"},
+	{"links", `"a":`},
+	{"nested", "%"},
+	{"span-styles", "%{a"},
+	{"span-langs", "%[a"},
+	{"span-classes", "%(a"},
+	{"image-styles", "!{a"},
+}
+
+func BenchmarkAdversarial(b *testing.B) {
+	for _, in := range adversarialInputs {
+		for _, n := range []int{1 << 10, 1 << 14, 1 << 18} {
+			d := []byte(strings.Repeat(in.repeat, n/len(in.repeat)))
+			b.Run(fmt.Sprintf("%s/%d", in.name, n), func(b *testing.B) {
+				b.SetBytes(int64(len(d)))
+				for i := 0; i < b.N; i++ {
+					ToHtml(d, false, false)
+				}
+			})
+		}
+	}
+}
+
+func TestAdversarialTerminates(t *testing.T) {
+	for _, in := range adversarialInputs {
+		d := []byte(strings.Repeat(in.repeat, 1<<16))
+		res := ToHtml(d, false, false)
+		if !bytes.HasPrefix(res, []byte("\t<p>")) {
+			t.Fatalf("%s: unexpected output %q", in.name, res[:20])
+		}
+	}
+}
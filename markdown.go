@@ -0,0 +1,577 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// MarkdownRenderer renders CommonMark with the GitHub extensions for
+// tables, strikethrough and footnotes. What markdown can't express, like
+// attributes, spans, acronyms and tables with colspans, is rendered as
+// html. Attributes of footnotes are dropped.
+type MarkdownRenderer struct {
+	// renders elements that markdown can't express
+	html *HtmlRenderer
+	// nesting level of blocks rendered as html
+	htmlLevel  int
+	lineBreaks LineBreaks
+	// number of blocks rendered in each block quote we're in
+	quotes []int
+	// lists we're in
+	lists []*mdList
+	// offsets in out where the items, block quotes and footnotes we're in
+	// start, their content is indented when they end
+	starts    []int
+	table     *mdTable
+	inCell    bool
+	inHeading bool
+	// names and urls of references used by links, in order of first use
+	refs    []string
+	refUrls map[string]string
+}
+
+type mdList struct {
+	ordered bool
+	items   int
+}
+
+type mdTable struct {
+	cols   int
+	header bool
+	rows   int
+}
+
+func NewMarkdownRenderer() *MarkdownRenderer {
+	h := NewHtmlRenderer()
+	h.format = FormatCompact
+	return &MarkdownRenderer{html: h, refUrls: make(map[string]string)}
+}
+
+// RenderMarkdown renders a document as markdown
+func RenderMarkdown(doc *Document) []byte {
+	return Render(doc, NewMarkdownRenderer())
+}
+
+// characters escaped in text, in table cells also '|'
+func needsMarkdownEscaping(b byte) bool {
+	switch b {
+	case '\\', '`', '*', '_', '[', ']', '<', '~':
+		return true
+	}
+	return false
+}
+
+// isMarkdownBlockStart returns true if s at the start of a line would
+// start a heading, block quote, list item or thematic break
+func isMarkdownBlockStart(s string) bool {
+	switch s[0] {
+	case '#', '>', '-', '+', '=':
+		return true
+	}
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i += 1
+	}
+	return i > 0 && i < len(s) && (s[i] == '.' || s[i] == ')')
+}
+
+func escapeMarkdown(s string, lineStart, inCell bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if needsMarkdownEscaping(b) || inCell && b == '|' {
+			buf.WriteByte('\\')
+		} else if b == '&' && isEntity(s[i:]) {
+			buf.WriteString("&amp;")
+			continue
+		} else if i == 0 && lineStart && isMarkdownBlockStart(s) {
+			if isDigit(b) {
+				// escape the . or ) after the number
+				n := strings.IndexAny(s, ".)")
+				buf.WriteString(s[:n])
+				buf.WriteByte('\\')
+				i = n
+				b = s[n]
+			} else {
+				buf.WriteByte('\\')
+			}
+		}
+		buf.WriteByte(b)
+	}
+	return buf.String()
+}
+
+// isEntity returns true if s starts with an html entity, e.g. &amp;
+func isEntity(s string) bool {
+	end := strings.IndexByte(s, ';')
+	if end < 2 {
+		return false
+	}
+	for _, c := range strings.TrimPrefix(s[1:end], "#") {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// mdCode returns s as a code span, delimited by enough backticks
+func mdCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// mdUrl returns url as a link destination
+func mdUrl(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(url) + ">"
+	}
+	return url
+}
+
+// prefixLines prefixes the first line of s with first and the other lines
+// with rest, which is trimmed for empty lines
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		switch {
+		case i == 0 && l == "":
+			lines[i] = strings.TrimRight(first, " ")
+		case i == 0:
+			lines[i] = first + l
+		case l == "":
+			lines[i] = strings.TrimRight(rest, " ")
+		default:
+			lines[i] = rest + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// htmlBlock returns true if a block is rendered as html: because it's in a
+// block rendered as html or because markdown can't express it (fallback)
+func (r *MarkdownRenderer) htmlBlock(out *bytes.Buffer, entering, fallback bool) bool {
+	if r.htmlLevel == 0 && !fallback {
+		return false
+	}
+	if entering {
+		if r.htmlLevel == 0 {
+			r.startBlock(out)
+		}
+		r.htmlLevel += 1
+	} else {
+		r.htmlLevel -= 1
+	}
+	return true
+}
+
+// startBlock separates a block from the previous one in the block quote
+// it's in
+func (r *MarkdownRenderer) startBlock(out *bytes.Buffer) {
+	if n := len(r.quotes); n > 0 {
+		if r.quotes[n-1] > 0 {
+			out.WriteString("\n\n")
+		}
+		r.quotes[n-1] += 1
+	}
+}
+
+func (r *MarkdownRenderer) pushStart(out *bytes.Buffer) {
+	r.starts = append(r.starts, out.Len())
+}
+
+// popStart removes what was rendered since the matching pushStart from out
+// and returns it
+func (r *MarkdownRenderer) popStart(out *bytes.Buffer) string {
+	start := r.starts[len(r.starts)-1]
+	r.starts = r.starts[:len(r.starts)-1]
+	s := string(out.Bytes()[start:])
+	out.Truncate(start)
+	return s
+}
+
+// atLineStart returns true if text written to out would start a line.
+// Markers of block quotes, list items and footnotes are prepended after
+// their content is rendered, so it starts lines too.
+func atLineStart(out *bytes.Buffer, start int) bool {
+	b := out.Bytes()
+	return len(b) == start || b[len(b)-1] == '\n'
+}
+
+func (r *MarkdownRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.html.DocumentHeader(out)
+	r.htmlLevel = 0
+	r.quotes = r.quotes[:0]
+	r.lists = r.lists[:0]
+	r.starts = r.starts[:0]
+	r.table = nil
+	r.inCell = false
+	r.refs = r.refs[:0]
+	r.refUrls = make(map[string]string)
+}
+
+// DocumentFooter writes definitions of the references used by links
+func (r *MarkdownRenderer) DocumentFooter(out *bytes.Buffer) {
+	for i, name := range r.refs {
+		if i == 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(fmt.Sprintf("\n[%s]: %s", name, mdUrl(r.refUrls[name])))
+	}
+}
+
+func (r *MarkdownRenderer) BlockSeparator(out *bytes.Buffer) {
+	out.WriteString("\n\n")
+}
+
+func (r *MarkdownRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if r.htmlBlock(out, entering, !n.Attrs.IsEmpty()) {
+		r.html.Paragraph(out, n, entering)
+		return
+	}
+	if entering {
+		r.startBlock(out)
+	}
+}
+
+func (r *MarkdownRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if r.htmlBlock(out, entering, !n.Attrs.IsEmpty()) {
+		r.html.Heading(out, n, entering)
+		return
+	}
+	if entering {
+		r.startBlock(out)
+		out.WriteString(strings.Repeat("#", n.Level) + " ")
+	}
+	r.inHeading = entering
+}
+
+func (r *MarkdownRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	if r.htmlBlock(out, entering, !n.Attrs.IsEmpty() || n.Cite != "") {
+		r.html.BlockQuote(out, n, entering)
+		return
+	}
+	if entering {
+		r.startBlock(out)
+		r.quotes = append(r.quotes, 0)
+		r.pushStart(out)
+		return
+	}
+	r.quotes = r.quotes[:len(r.quotes)-1]
+	out.WriteString(prefixLines(r.popStart(out), "> ", "> "))
+}
+
+func (r *MarkdownRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if r.htmlBlock(out, entering, !n.Attrs.IsEmpty()) {
+		r.html.List(out, n, entering)
+		return
+	}
+	if !entering {
+		r.lists = r.lists[:len(r.lists)-1]
+		return
+	}
+	if len(r.lists) > 0 {
+		// a nested list starts on a new line of the item it's in
+		out.WriteString("\n")
+	} else {
+		r.startBlock(out)
+	}
+	r.lists = append(r.lists, &mdList{ordered: n.Ordered})
+}
+
+func (r *MarkdownRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	if r.htmlBlock(out, entering, false) {
+		r.html.ListItem(out, n, entering)
+		return
+	}
+	list := r.lists[len(r.lists)-1]
+	if entering {
+		if list.items > 0 {
+			out.WriteString("\n")
+		}
+		list.items += 1
+		r.pushStart(out)
+		return
+	}
+	marker := "- "
+	if list.ordered {
+		marker = fmt.Sprintf("%d. ", list.items)
+	}
+	out.WriteString(prefixLines(r.popStart(out), marker, strings.Repeat(" ", len(marker))))
+}
+
+func (r *MarkdownRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	if r.htmlLevel > 0 || !n.Attrs.IsEmpty() {
+		r.startBlock(out)
+		r.html.CodeBlock(out, n)
+		return
+	}
+	r.startBlock(out)
+	fence := "```"
+	for strings.Contains(n.Text, fence) {
+		fence += "`"
+	}
+	out.WriteString(fmt.Sprintf("%s\n%s\n%s", fence, n.Text, fence))
+}
+
+func (r *MarkdownRenderer) HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool) {
+	r.htmlBlock(out, entering, true)
+	r.html.HTMLBlock(out, n, entering)
+}
+
+// isMarkdownTable returns true if t can be a markdown table: without
+// attributes, spans and header cells outside of the first row
+func isMarkdownTable(t *Table) bool {
+	if !t.Attrs.IsEmpty() || len(t.Nodes) == 0 {
+		return false
+	}
+	for i, row := range t.Nodes {
+		row, ok := row.(*TableRow)
+		if !ok || !row.Attrs.IsEmpty() {
+			return false
+		}
+		for _, cell := range row.Nodes {
+			cell, ok := cell.(*TableCell)
+			if !ok || !cell.Attrs.IsEmpty() || cell.Colspan > 0 || cell.Rowspan > 0 || cell.Header && i > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *MarkdownRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if r.htmlBlock(out, entering, !isMarkdownTable(n)) {
+		r.html.Table(out, n, entering)
+		return
+	}
+	if !entering {
+		r.table = nil
+		return
+	}
+	r.startBlock(out)
+	r.table = &mdTable{header: true}
+	for i, row := range n.Nodes {
+		cells := row.Children()
+		if len(cells) > r.table.cols {
+			r.table.cols = len(cells)
+		}
+		for _, cell := range cells {
+			if i == 0 && !cell.(*TableCell).Header {
+				r.table.header = false
+			}
+		}
+	}
+	if !r.table.header {
+		// markdown tables have a header, this one is empty
+		out.WriteString("|" + strings.Repeat("  |", r.table.cols) + "\n")
+		r.writeTableDelimiter(out)
+		out.WriteString("\n")
+	}
+}
+
+func (r *MarkdownRenderer) writeTableDelimiter(out *bytes.Buffer) {
+	out.WriteString("|" + strings.Repeat(" --- |", r.table.cols))
+}
+
+func (r *MarkdownRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	if r.htmlBlock(out, entering, false) {
+		r.html.TableRow(out, n, entering)
+		return
+	}
+	if entering {
+		if r.table.rows > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString("|")
+		return
+	}
+	for i := len(n.Nodes); i < r.table.cols; i++ {
+		out.WriteString("  |")
+	}
+	if r.table.rows == 0 && r.table.header {
+		out.WriteString("\n")
+		r.writeTableDelimiter(out)
+	}
+	r.table.rows += 1
+}
+
+func (r *MarkdownRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	if r.htmlBlock(out, entering, false) {
+		r.html.TableCell(out, n, entering)
+		return
+	}
+	r.inCell = entering
+	if entering {
+		out.WriteString(" ")
+	} else {
+		out.WriteString(" |")
+	}
+}
+
+func (r *MarkdownRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	if entering {
+		r.pushStart(out)
+		return
+	}
+	out.WriteString(prefixLines(r.popStart(out), fmt.Sprintf("[^%s]: ", n.ID), "    "))
+}
+
+func (r *MarkdownRenderer) Text(out *bytes.Buffer, n *Text) {
+	if r.htmlLevel > 0 {
+		r.html.Text(out, n)
+		return
+	}
+	start := 0
+	if len(r.starts) > 0 {
+		start = r.starts[len(r.starts)-1]
+	}
+	s := n.Text
+	if atLineStart(out, start) {
+		// indented lines are code blocks
+		s = strings.TrimLeft(s, " \t")
+		out.WriteString(escapeMarkdown(s, true, r.inCell))
+		return
+	}
+	out.WriteString(escapeMarkdown(s, false, r.inCell))
+}
+
+func (r *MarkdownRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	switch {
+	case r.htmlLevel > 0 || r.inCell || r.inHeading:
+		// a heading or cell is a single line
+		out.WriteString("<br>")
+	case r.lineBreaks == LineBreaksNewline:
+		out.WriteString("\n")
+	case r.lineBreaks == LineBreaksSpace:
+		out.WriteString(" ")
+	default:
+		out.WriteString("\\\n")
+	}
+}
+
+// markdownPhrases are the delimiters of phrases that markdown has, the
+// others are html. <i> and <b> become emphasis and strong emphasis, which
+// is what they look like.
+var markdownPhrases = map[string]string{
+	"em":     "*",
+	"i":      "*",
+	"strong": "**",
+	"b":      "**",
+	"del":    "~~",
+}
+
+// phraseDelimiter returns the markdown delimiter of n, or "" if it must be
+// html
+func phraseDelimiter(n *Phrase) string {
+	delim := markdownPhrases[n.Tag]
+	if delim == "" || !n.Attrs.IsEmpty() {
+		return ""
+	}
+	// emphasis can't start or end with a space
+	s := textOf(n)
+	if s == "" || s != strings.TrimSpace(s) {
+		return ""
+	}
+	return delim
+}
+
+func (r *MarkdownRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	delim := phraseDelimiter(n)
+	if r.htmlLevel > 0 || delim == "" {
+		r.html.Phrase(out, n, entering)
+		return
+	}
+	out.WriteString(delim)
+}
+
+func (r *MarkdownRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+	r.html.Span(out, n, entering)
+}
+
+func (r *MarkdownRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	if r.htmlLevel > 0 {
+		r.html.CodeSpan(out, n)
+		return
+	}
+	s := mdCode(n.Text)
+	if r.inCell {
+		s = strings.Replace(s, "|", "\\|", -1)
+	}
+	out.WriteString(s)
+}
+
+func (r *MarkdownRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	out.WriteString(fmt.Sprintf(`<abbr title="%s">%s</abbr>`, escapeAttr(n.Title), escapeText(n.Text)))
+}
+
+func (r *MarkdownRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if r.htmlLevel > 0 || n.Missing {
+		r.html.Link(out, n, entering)
+		return
+	}
+	if entering {
+		openBracket(out)
+		return
+	}
+	if n.Ref == "" {
+		out.WriteString(fmt.Sprintf("](%s)", mdUrl(n.URL)))
+		return
+	}
+	if _, ok := r.refUrls[n.Ref]; !ok {
+		r.refs = append(r.refs, n.Ref)
+		r.refUrls[n.Ref] = n.URL
+	}
+	out.WriteString(fmt.Sprintf("][%s]", n.Ref))
+}
+
+func (r *MarkdownRenderer) Image(out *bytes.Buffer, n *Image) {
+	if r.htmlLevel > 0 || !n.Attrs.IsEmpty() || n.Width > 0 || n.Height > 0 || n.Loading != "" {
+		r.html.Image(out, n)
+		return
+	}
+	img := fmt.Sprintf("![%s](%s)", escapeMarkdown(n.Alt, false, r.inCell), mdUrl(n.Src))
+	if n.URL != "" {
+		openBracket(out)
+		img = fmt.Sprintf("%s](%s)", img, mdUrl(n.URL))
+	}
+	out.WriteString(img)
+}
+
+func (r *MarkdownRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	out.WriteString(n.HTML)
+}
+
+func (r *MarkdownRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	if r.htmlLevel > 0 {
+		r.html.FootnoteRef(out, n)
+		return
+	}
+	openBracket(out)
+	out.WriteString(fmt.Sprintf("^%s]", n.ID))
+}
+
+// openBracket writes a [ that starts a link or footnote reference,
+// escaping a ! right before it, which would make the link an image
+func openBracket(out *bytes.Buffer) {
+	b := out.Bytes()
+	if len(b) > 0 && b[len(b)-1] == '!' {
+		// the ! is escaped already if an odd number of \ precede it
+		n := 0
+		for i := len(b) - 2; i >= 0 && b[i] == '\\'; i-- {
+			n += 1
+		}
+		if n%2 == 0 {
+			out.Truncate(len(b) - 1)
+			out.WriteString("\\!")
+		}
+	}
+	out.WriteByte('[')
+}
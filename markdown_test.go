@@ -0,0 +1,58 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestMarkdownCorpus(t *testing.T) {
+	checkGolden(t, "markdown.golden", renderCorpus(t, &Options{Flavor: FlavorMarkdown}))
+}
+
+func TestMarkdown(t *testing.T) {
+	tests := []string{
+		"h2. Title\n\nSome *strong* and _em_ text, -del- and @co`de@.",
+		"## Title\n\nSome **strong** and *em* text, ~~del~~ and ``co`de``.",
+
+		"# one\n## two\n\n* a\n*** b",
+		"1. one\n   1. two\n\n- a\n  -\n    - b",
+
+		"bq. quoted *text*\n\nbq.:http://a.com cited",
+		"> quoted **text**\n\n<blockquote cite=\"http://a.com\"><p>cited</p></blockquote>",
+
+		"|_. a|_. b|\n|c|d|\n\n|a|b|",
+		"| a | b |\n| --- | --- |\n| c | d |\n\n|  |  |\n| --- | --- |\n| a | b |",
+
+		"Hi !\"a\":http://x.com\n\nnote![1]\n\nh1. a\nb",
+		"Hi \\![a](http://x.com)\n\nnote\\![^1]\n\n# a<br>b",
+
+		"|\\2. span|\n|a|b|",
+		"<table><tr><td colspan=\"2\">span</td></tr><tr><td>a</td><td>b</td></tr></table>",
+
+		"\"a\":x and \"b\":x and \"c\":http://c.com/a_(b)\n\n[x]http://x.com",
+		"[a][x] and [b][x] and [c](<http://c.com/a_(b>))\n\n[x]: http://x.com",
+
+		"Note[1].\n\nfn1. The note.",
+		"Note[^1].\n\n[^1]: The note.",
+
+		"p(intro). Hello %{color:red}red% CSS(Cascading Style Sheets)",
+		"<p class=\"intro\">Hello <span style=\"color:red;\">red</span> <abbr title=\"Cascading Style Sheets\">CSS</abbr></p>",
+
+		"Hello %{color:red}red *text*%",
+		"Hello <span style=\"color:red;\">red **text**</span>",
+
+		"!(big)cat.png! !dog.png(Dog)!:http://dog.com",
+		"<img src=\"cat.png\" class=\"big\" alt=\"\"> [![Dog](dog.png)](http://dog.com)",
+
+		"bc. ```\ncode\n```",
+		"````\n```\ncode\n```\n````",
+
+		"1. not a list # [not] a <link> a*b*c 5 &amp; &",
+		"1\\. not a list # \\[not\\] a \\<link> a\\*b\\*c 5 &amp;amp; &",
+	}
+	opts := &Options{Flavor: FlavorMarkdown}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package textiler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Flavor is the kind of markup a document is converted to
+type Flavor int
+
+const (
+	// FlavorHtml is html compatible with the output of python-textile,
+	// e.g. ACRONYM(title) is an <acronym>
+	FlavorHtml Flavor = iota
+	// FlavorXhtml differs from FlavorHtml in closing empty elements and in
+	// being well-formed xml
+	FlavorXhtml
+	// FlavorHtml5 is html5 with semantic elements, e.g. <abbr> and
+	// <figure>
+	FlavorHtml5
+	// FlavorMarkdown is CommonMark with GitHub's tables, strikethrough and
+	// footnotes, see MarkdownRenderer
+	FlavorMarkdown
+	// FlavorText is plain text without markup, see TextRenderer
+	FlavorText
+	// FlavorLatex is LaTeX, see LatexRenderer
+	FlavorLatex
+	// FlavorTerminal is text styled with ANSI escapes, see
+	// TerminalRenderer
+	FlavorTerminal
+)
+
+// LineBreaks says how line breaks inside of a block are rendered
+type LineBreaks int
+
+const (
+	// LineBreaksBr renders line breaks as <br> followed by a newline
+	LineBreaksBr LineBreaks = iota
+	// LineBreaksNewline keeps line breaks as newlines
+	LineBreaksNewline
+	// LineBreaksSpace joins lines with a space
+	LineBreaksSpace
+)
+
+// Format is the layout of the output, i.e. the whitespace between elements
+type Format int
+
+const (
+	// FormatTextile is the layout of the output of python-textile
+	FormatTextile Format = iota
+	// FormatPretty puts each block element on its own line, indented by
+	// Options.Indent for each level of nesting
+	FormatPretty
+	// FormatCompact has no whitespace between elements
+	FormatCompact
+)
+
+// Hooks are called during conversion to inspect or change the result
+type Hooks struct {
+	// Block, if set, is called for each top-level block after it's parsed
+	// and before it's rendered. It may modify the block in place.
+	Block func(n Node)
+	// Renderer, if set, is called with the renderer for the flavor and
+	// returns the renderer to use instead, e.g. one that embeds it
+	Renderer func(r Renderer) Renderer
+}
+
+// Options configure a conversion. The zero value (or a nil *Options)
+// converts to html with DefaultLimits.
+type Options struct {
+	Flavor Flavor
+	// Glyphs turns quotes, dashes, ellipses, (c) etc. into typographic
+	// characters
+	Glyphs bool
+	// Restricted is for untrusted input: html tags and notextile. are
+	// escaped, links with urls other than http(s), ftp, mailto or relative
+	// ones are rendered as their text and such images are left out
+	Restricted bool
+	LineBreaks LineBreaks
+	Format     Format
+	// Indent is the indentation of FormatPretty, a tab if empty
+	Indent string
+	// Wrap is the width FlavorText and FlavorTerminal wrap lines at, 0
+	// doesn't wrap them
+	Wrap int
+	// LinkUrls makes FlavorText write the urls of links in brackets after
+	// their text, FlavorTerminal always does
+	LinkUrls bool
+	// NoColor makes FlavorTerminal write no ANSI escapes, e.g. if the
+	// output isn't a terminal
+	NoColor bool
+	// Standalone makes FlavorLatex write a complete document instead of a
+	// fragment
+	Standalone bool
+	// Preamble is what a standalone FlavorLatex document starts with
+	// before \begin{document}, DefaultLatexPreamble if empty
+	Preamble string
+	// IDPrefix is prepended to ids generated for footnotes and headings,
+	// to keep them unique if several documents end up on one page
+	IDPrefix string
+	// HeadingIDs generates ids for headings that don't have one, see
+	// AddHeadingIDs and Convert
+	HeadingIDs bool
+	// TOC replaces paragraphs that are just {toc} with the table of
+	// contents, see TOC. It implies HeadingIDs.
+	TOC bool
+	// FrontMatter parses key: value lines between --- lines at the start
+	// of the input into Document.Meta instead of rendering them, see
+	// ConvertWithMeta
+	FrontMatter bool
+	// TitleFromHeading sets the title of Document.Meta to the text of the
+	// first h1. if the front matter doesn't have one
+	TitleFromHeading bool
+	Hooks            Hooks
+	// Blocks are custom block signatures, see RegisterBlock
+	Blocks map[string]BlockFunc
+	// Phrases are custom phrase modifiers by their markers, see
+	// RegisterPhrase
+	Phrases map[string]PhraseModifier
+	// LinkResolver, if set, resolves links that aren't resolved by
+	// references
+	LinkResolver LinkResolver
+	// WikiLinks enables [[Page]] and [[Page|text]] links to pages, which
+	// are resolved with LinkResolver
+	WikiLinks bool
+	// ImageRoot, if set, is where local images are looked up to set their
+	// width and height. Images that don't exist are reported as
+	// diagnostics.
+	ImageRoot fs.FS
+	// ImageHook, if set, is called for each image after its size is set
+	ImageHook ImageHook
+	// Refs are link references available to all documents, in addition to
+	// the ones they define with [name]url lines, which take precedence.
+	// They are only read, so they can be shared by concurrent conversions.
+	Refs map[string]string
+	// Limits bound the work done on the input, see Limits
+	Limits Limits
+	// Trace, if not nil, receives the input lines and the parsed blocks,
+	// for debugging
+	Trace io.Writer
+}
+
+var (
+	errUnknownFlavor     = errors.New("textiler: unknown flavor")
+	errUnknownLineBreaks = errors.New("textiler: unknown line breaks")
+	errUnknownFormat     = errors.New("textiler: unknown format")
+	errInvalidIndent     = errors.New("textiler: indent must be spaces and tabs")
+	errInvalidWrap       = errors.New("textiler: wrap must not be negative")
+)
+
+func (opts *Options) validate() error {
+	if opts == nil {
+		return nil
+	}
+	switch opts.Flavor {
+	case FlavorHtml, FlavorXhtml, FlavorHtml5, FlavorMarkdown, FlavorText, FlavorLatex, FlavorTerminal:
+	default:
+		return errUnknownFlavor
+	}
+	switch opts.LineBreaks {
+	case LineBreaksBr, LineBreaksNewline, LineBreaksSpace:
+	default:
+		return errUnknownLineBreaks
+	}
+	switch opts.Format {
+	case FormatTextile, FormatPretty, FormatCompact:
+	default:
+		return errUnknownFormat
+	}
+	if strings.Trim(opts.Indent, " \t") != "" {
+		return errInvalidIndent
+	}
+	if opts.Wrap < 0 {
+		return errInvalidWrap
+	}
+	for name := range opts.Blocks {
+		if !isBlockName(name) {
+			return fmt.Errorf("textiler: invalid block name %q", name)
+		}
+	}
+	for marker, mod := range opts.Phrases {
+		if err := validatePhrase(marker, mod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configure sets up p for converting with opts
+func (opts *Options) configure(p *TextileParser) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p.flags = 0
+	if opts.Flavor == FlavorXhtml {
+		p.flags = RENDERER_XHTML
+	}
+	p.SetLimits(opts.Limits)
+	p.glyphs = opts.Glyphs
+	p.restricted = opts.Restricted
+	p.blockHook = opts.Hooks.Block
+	p.slugs = nil
+	if opts.HeadingIDs || opts.TOC {
+		p.slugs = newSlugger(opts.IDPrefix)
+	}
+	p.toc = opts.TOC
+	p.frontMatter = opts.FrontMatter
+	p.titleFromHeading = opts.TitleFromHeading
+	p.globalRefs = opts.Refs
+	p.linkResolver = opts.LinkResolver
+	p.wikiLinks = opts.WikiLinks
+	p.imageRoot = opts.ImageRoot
+	p.imageHook = opts.ImageHook
+	p.blocks = opts.Blocks
+	p.setPhrases(opts.Phrases)
+	p.trace = opts.Trace
+}
+
+var parserPool = sync.Pool{
+	New: func() interface{} {
+		return NewParser(0)
+	},
+}
+
+// getParser returns a parser from the pool, set up for opts
+func getParser(opts *Options) *TextileParser {
+	p := parserPool.Get().(*TextileParser)
+	opts.configure(p)
+	return p
+}
+
+func putParser(p *TextileParser) {
+	p.Reset()
+	p.trace = nil
+	p.blockHook = nil
+	p.slugs = nil
+	p.toc = false
+	p.frontMatter = false
+	p.titleFromHeading = false
+	p.globalRefs = nil
+	p.linkResolver = nil
+	p.imageRoot = nil
+	p.imageHook = nil
+	p.blocks = nil
+	p.setPhrases(nil)
+	parserPool.Put(p)
+}
+
+// NewRenderer returns the renderer for the flavor, line breaks, format, id
+// prefix, wrapping, colors and LaTeX preamble of opts, wrapped by opts.Hooks.Renderer if it's set
+func NewRenderer(opts *Options) Renderer {
+	if opts == nil {
+		return NewHtmlRenderer()
+	}
+	h := NewHtmlRenderer()
+	h.lineBreaks = opts.LineBreaks
+	h.format = opts.Format
+	h.indentBy = opts.Indent
+	if h.indentBy == "" {
+		h.indentBy = "\t"
+	}
+	h.idPrefix = opts.IDPrefix
+	var r Renderer = h
+	switch opts.Flavor {
+	case FlavorXhtml:
+		r = &XhtmlRenderer{HtmlRenderer: h}
+	case FlavorHtml5:
+		r = &Html5Renderer{HtmlRenderer: h}
+	case FlavorMarkdown:
+		m := NewMarkdownRenderer()
+		m.lineBreaks = opts.LineBreaks
+		r = m
+	case FlavorText:
+		t := NewTextRenderer()
+		t.width = opts.Wrap
+		t.linkUrls = opts.LinkUrls
+		t.lineBreaks = opts.LineBreaks
+		r = t
+	case FlavorTerminal:
+		t := NewTerminalRenderer()
+		t.width = opts.Wrap
+		t.lineBreaks = opts.LineBreaks
+		t.color = !opts.NoColor
+		r = t
+	case FlavorLatex:
+		l := NewLatexRenderer()
+		l.standalone = opts.Standalone
+		if opts.Preamble != "" {
+			l.preamble = opts.Preamble
+		}
+		r = l
+	}
+	if opts.Hooks.Renderer != nil {
+		r = opts.Hooks.Renderer(r)
+	}
+	return r
+}
+
+// ConvertBytes converts textile in d as configured by opts, which may be
+// nil. It returns the output and diagnostics, i.e. problems found in the
+// input, which don't stop the conversion. The error is not nil if opts are
+// invalid or writing to opts.Trace failed.
+//
+// ConvertBytes reuses parsers, so it's cheap to call on many small inputs
+// and safe to call from multiple goroutines.
+func ConvertBytes(d []byte, opts *Options) ([]byte, []Diagnostic, error) {
+	res, _, diags, err := ConvertWithMeta(d, opts)
+	return res, diags, err
+}
+
+// ConvertWithMeta is like ConvertBytes, but also returns the metadata of
+// the document, i.e. its front matter and title (see Options.FrontMatter
+// and Options.TitleFromHeading), nil if there's none.
+func ConvertWithMeta(d []byte, opts *Options) ([]byte, map[string]string, []Diagnostic, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	p := getParser(opts)
+	defer putParser(p)
+	doc := p.parse(d)
+	if p.traceErr != nil {
+		return nil, nil, nil, p.traceErr
+	}
+	return Render(doc, NewRenderer(opts)), doc.Meta, doc.Diagnostics, nil
+}
@@ -0,0 +1,127 @@
+package textiler
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func convertBytes(t *testing.T, s string, opts *Options) string {
+	res, _, err := ConvertBytes([]byte(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(res)
+}
+
+func TestGlyphs(t *testing.T) {
+	// 4 - python-textile adds a space after the closing quote
+	glyphTests := []int{5, 6, 7, 8, 9, 10, 59, 80, 81}
+	for _, i := range glyphTests {
+		s := XhtmlTests[i*2]
+		actual := convertBytes(t, s, &Options{Flavor: FlavorXhtml, Glyphs: true})
+		expected := XhtmlTests[i*2+1]
+		if actual != expected {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, expected, actual)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		src  string
+		opts *Options
+		exp  string
+	}{
+		{"a\nb", nil, "\t<p>a<br>\nb</p>"},
+		{"a\nb", &Options{Flavor: FlavorXhtml}, "\t<p>a<br />\nb</p>"},
+		{"a\nb", &Options{LineBreaks: LineBreaksNewline}, "\t<p>a\nb</p>"},
+		{"a\nb", &Options{Flavor: FlavorXhtml, LineBreaks: LineBreaksSpace}, "\t<p>a b</p>"},
+		{"a[1]\n\nfn1. b", &Options{IDPrefix: "x-"},
+			"\t<p>a<sup class=\"footnote\" id=\"x-fnrev1\"><a href=\"#x-fn1\">1</a></sup></p>\n\n\t<p class=\"footnote\" id=\"x-fn1\"><sup>1</sup> b</p>"},
+		{"<b>a</b> \"b\":javascript:alert \"c\":/c !javascript:x(d)!", &Options{Restricted: true},
+			"\t<p>&lt;b&gt;a&lt;/b&gt; b <a href=\"/c\">c</a> d</p>"},
+		{"notextile. <b>", &Options{Restricted: true}, "\t<p>notextile. &lt;b&gt;</p>"},
+	}
+	for _, test := range tests {
+		if got := convertBytes(t, test.src, test.opts); got != test.exp {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", test.src, test.exp, got)
+		}
+	}
+}
+
+func TestOptionsHooks(t *testing.T) {
+	opts := &Options{Hooks: Hooks{
+		Block: func(n Node) {
+			if p, ok := n.(*Paragraph); ok {
+				p.Attrs.Class = "hooked"
+			}
+		},
+		Renderer: func(r Renderer) Renderer {
+			return &pictureRenderer{r}
+		},
+	}}
+	got := convertBytes(t, "a !b.png!", opts)
+	exp := "\t<p class=\"hooked\">a <picture><source srcset=\"b.png.webp\"><img src=\"b.png\" alt=\"\"></picture></p>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	s := "h1. Title\n\nbq. quoted\nline\n\n* a\n** b\n* c\n\n|a|b|"
+	tests := []struct {
+		opts *Options
+		exp  string
+	}{
+		{&Options{Format: FormatPretty, Indent: "  "}, "<h1>Title</h1>\n" +
+			"<blockquote>\n  <p>quoted<br>\nline</p>\n</blockquote>\n" +
+			"<ul>\n  <li>a\n    <ul>\n      <li>b</li>\n    </ul>\n  </li>\n  <li>c</li>\n</ul>\n" +
+			"<table>\n  <tr>\n    <td>a</td>\n    <td>b</td>\n  </tr>\n</table>"},
+		{&Options{Format: FormatPretty}, "<h1>Title</h1>\n" +
+			"<blockquote>\n\t<p>quoted<br>\nline</p>\n</blockquote>\n" +
+			"<ul>\n\t<li>a\n\t\t<ul>\n\t\t\t<li>b</li>\n\t\t</ul>\n\t</li>\n\t<li>c</li>\n</ul>\n" +
+			"<table>\n\t<tr>\n\t\t<td>a</td>\n\t\t<td>b</td>\n\t</tr>\n</table>"},
+		{&Options{Format: FormatCompact}, "<h1>Title</h1>" +
+			"<blockquote><p>quoted<br>line</p></blockquote>" +
+			"<ul><li>a<ul><li>b</li></ul></li><li>c</li></ul>" +
+			"<table><tr><td>a</td><td>b</td></tr></table>"},
+	}
+	for _, test := range tests {
+		if got := convertBytes(t, s, test.opts); got != test.exp {
+			t.Fatalf("\nExp:%#v\nGot:%#v\n", test.exp, got)
+		}
+	}
+	// the default layout is the one of python-textile
+	if got, exp := convertBytes(t, s, &Options{Format: FormatTextile}), textileToHtml(s); got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestConvertBytesErrors(t *testing.T) {
+	if _, _, err := ConvertBytes([]byte("a"), &Options{Flavor: 100}); err == nil {
+		t.Fatal("expected an error for an unknown flavor")
+	}
+	if _, _, err := ConvertBytes([]byte("a"), &Options{LineBreaks: 100}); err == nil {
+		t.Fatal("expected an error for unknown line breaks")
+	}
+	if _, _, err := ConvertBytes([]byte("a"), &Options{Format: 100}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+	if _, _, err := ConvertBytes([]byte("a"), &Options{Indent: "--"}); err == nil {
+		t.Fatal("expected an error for an invalid indent")
+	}
+	if _, _, err := ConvertBytes([]byte("a"), &Options{Trace: failingWriter{}}); err != io.ErrShortWrite {
+		t.Fatalf("expected the trace write error, got %v", err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	convertBytes(t, "h1. _a_", &Options{Trace: &buf})
+	exp := "'h1. _a_'\nHeading\n  Phrase\n    Text \"a\"\n"
+	if got := buf.String(); !strings.HasSuffix(got, exp) {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
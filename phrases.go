@@ -0,0 +1,156 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// PhraseModifier is a custom inline modifier like ||spoiler||, registered
+// with Options.RegisterPhrase or Options.RegisterPhraseFunc
+type PhraseModifier struct {
+	// Tag is the name of the html element of the phrase, used if Func is
+	// nil
+	Tag string
+	// Func returns the node for a phrase with the given attributes and
+	// parsed text. The node must be one that renderers know, e.g. a
+	// *Phrase, a *Span or a *RawHTML.
+	Func func(attrs Attributes, children []Node) Node
+}
+
+// isMarker returns true if m can mark a custom phrase: one or two ASCII
+// punctuation characters
+func isMarker(m string) bool {
+	if len(m) == 0 || len(m) > 2 {
+		return false
+	}
+	for i := 0; i < len(m); i++ {
+		c := m[i]
+		if c <= ' ' || c >= 0x7f || isChar(c) || isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTagName(tag string) bool {
+	for i := 0; i < len(tag); i++ {
+		if !(isChar(tag[i]) || i > 0 && isDigit(tag[i])) {
+			return false
+		}
+	}
+	return len(tag) > 0
+}
+
+func validatePhrase(marker string, mod PhraseModifier) error {
+	if !isMarker(marker) {
+		return fmt.Errorf("textiler: invalid phrase marker %q", marker)
+	}
+	if mod.Func == nil && !isTagName(mod.Tag) {
+		return fmt.Errorf("textiler: invalid tag %q for phrase marker %q", mod.Tag, marker)
+	}
+	return nil
+}
+
+// RegisterPhrase registers a phrase modifier that renders text between two
+// markers as the element tag, e.g. RegisterPhrase("::", "kbd") makes
+// ::Ctrl:: <kbd>Ctrl</kbd>. Like with the built-in ones (*strong*, -del-
+// etc.), the opening marker can be followed by attributes, must be at the
+// start of a word and the closing one at its end. Custom markers are tried
+// before the built-in ones, longer ones first.
+func (opts *Options) RegisterPhrase(marker, tag string) error {
+	return opts.registerPhrase(marker, PhraseModifier{Tag: tag})
+}
+
+// RegisterPhraseFunc is like RegisterPhrase, but phrases are converted to
+// nodes by fn
+func (opts *Options) RegisterPhraseFunc(marker string, fn func(attrs Attributes, children []Node) Node) error {
+	return opts.registerPhrase(marker, PhraseModifier{Func: fn})
+}
+
+func (opts *Options) registerPhrase(marker string, mod PhraseModifier) error {
+	if err := validatePhrase(marker, mod); err != nil {
+		return err
+	}
+	if opts.Phrases == nil {
+		opts.Phrases = make(map[string]PhraseModifier)
+	}
+	opts.Phrases[marker] = mod
+	return nil
+}
+
+// setPhrases sets custom phrase modifiers of the parser
+func (p *TextileParser) setPhrases(phrases map[string]PhraseModifier) {
+	p.phrases = phrases
+	p.phraseMarkers = p.phraseMarkers[:0]
+	p.phraseStarts = [128]bool{}
+	for m := range phrases {
+		p.phraseMarkers = append(p.phraseMarkers, m)
+		p.phraseStarts[m[0]] = true
+	}
+	sort.Slice(p.phraseMarkers, func(i, j int) bool {
+		mi, mj := p.phraseMarkers[i], p.phraseMarkers[j]
+		if len(mi) != len(mj) {
+			return len(mi) > len(mj)
+		}
+		return mi < mj
+	})
+}
+
+// isPhraseStart returns true if b can start a custom phrase
+func (p *TextileParser) isPhraseStart(b byte) bool {
+	return b < 0x80 && p.phraseStarts[b]
+}
+
+// find marker followed by punctuation
+func parseMarkerInside(l []byte, marker string) (rest, inside []byte) {
+	for i := 1; i+len(marker) <= len(l); i++ {
+		if !bytes.HasPrefix(l[i:], []byte(marker)) {
+			continue
+		}
+		rest = l[i+len(marker):]
+		if len(rest) == 0 || isPunctOrSpace(rest[0]) {
+			inside = l[:i]
+			if inside[len(inside)-1] == ' ' {
+				return nil, nil
+			}
+			return rest, inside
+		}
+	}
+	return nil, nil
+}
+
+// parseCustomPhrase parses a custom phrase at the start of rest
+func (p *TextileParser) parseCustomPhrase(c *Container, before, rest []byte) []byte {
+	if !endsWithPunctOrSpace(before) {
+		return nil
+	}
+	for _, marker := range p.phraseMarkers {
+		if !bytes.HasPrefix(rest, []byte(marker)) {
+			continue
+		}
+		l, attrs := parseAttributesOpt(rest[len(marker):], false)
+		after, inside := parseMarkerInside(l, marker)
+		if after == nil {
+			p.spend(len(l))
+			continue
+		}
+		p.spend(len(inside))
+		p.addCustomPhrase(c, p.phrases[marker], attrs, before, inside)
+		return after
+	}
+	return nil
+}
+
+func (p *TextileParser) addCustomPhrase(c *Container, mod PhraseModifier, attrs *AttributesOpt, before, inside []byte) {
+	if mod.Func == nil {
+		p.addPhrase(c, mod.Tag, attrs, before, inside)
+		return
+	}
+	p.addText(c, before)
+	var children Container
+	p.parseInline(&children, inside)
+	if n := mod.Func(attrs.attributes(), children.Nodes); n != nil {
+		c.AppendChild(n)
+	}
+}
@@ -0,0 +1,64 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestCustomPhrases(t *testing.T) {
+	opts := &Options{}
+	opts.RegisterPhrase("::", "kbd")
+	opts.RegisterPhrase("=", "mark")
+	opts.RegisterPhraseFunc("||", func(attrs Attributes, children []Node) Node {
+		attrs.Class = "spoiler"
+		n := &Span{Attrs: attrs}
+		n.Nodes = children
+		return n
+	})
+	tests := []string{
+		"Press ::Ctrl:: + ::C::.",
+		"\t<p>Press <kbd>Ctrl</kbd> + <kbd>C</kbd>.</p>",
+
+		"The ||butler _did_ it||!",
+		"\t<p>The <span class=\"spoiler\">butler <em>did</em> it</span>!</p>",
+
+		"a =(hl)marked= word and a=b=c",
+		"\t<p>a <mark class=\"hl\">marked</mark> word and a=b=c</p>",
+
+		// markers must be at the start and the end of words
+		"a::b:: and ::c :: d",
+		"\t<p>a::b:: and ::c :: d</p>",
+
+		"*strong ::key::*",
+		"\t<p><strong>strong <kbd>key</kbd></strong></p>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		s := tests[i]
+		got, _, err := ConvertBytes([]byte(s), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, tests[i+1], string(got))
+		}
+	}
+	// phrases are registered per conversion
+	if got := textileToHtml("::a::"); got != "\t<p>::a::</p>" {
+		t.Fatalf("unexpected %#v", got)
+	}
+}
+
+func TestRegisterPhraseErrors(t *testing.T) {
+	opts := &Options{}
+	for _, marker := range []string{"", "a", ":::", " "} {
+		if err := opts.RegisterPhrase(marker, "kbd"); err == nil {
+			t.Fatalf("expected an error for %q", marker)
+		}
+	}
+	if err := opts.RegisterPhrase("::", "k bd"); err == nil {
+		t.Fatal("expected an error for an invalid tag")
+	}
+	opts.Phrases = map[string]PhraseModifier{"::": {}}
+	if _, _, err := ConvertBytes([]byte("a"), opts); err == nil {
+		t.Fatal("expected an error for a phrase without a tag")
+	}
+}
@@ -0,0 +1,158 @@
+package textiler
+
+import (
+	"bytes"
+)
+
+// Renderer renders nodes of a document tree, one method per kind of node.
+//
+// Methods of nodes with children are called twice: with entering set to
+// true before the children are rendered and with entering set to false
+// after that. To change the output of a single kind of node, embed an
+// existing Renderer and override its method, e.g.:
+//
+//	type pictureRenderer struct {
+//		textiler.Renderer
+//	}
+//
+//	func (r *pictureRenderer) Image(out *bytes.Buffer, n *textiler.Image) {
+//		...
+//	}
+type Renderer interface {
+	// DocumentHeader is called before the first block of a document
+	DocumentHeader(out *bytes.Buffer)
+	// DocumentFooter is called after the last block of a document
+	DocumentFooter(out *bytes.Buffer)
+	// BlockSeparator is called between top-level blocks of a document
+	BlockSeparator(out *bytes.Buffer)
+
+	// block-level elements
+	Paragraph(out *bytes.Buffer, n *Paragraph, entering bool)
+	Heading(out *bytes.Buffer, n *Heading, entering bool)
+	BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool)
+	List(out *bytes.Buffer, n *List, entering bool)
+	ListItem(out *bytes.Buffer, n *ListItem, entering bool)
+	CodeBlock(out *bytes.Buffer, n *CodeBlock)
+	HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool)
+	Table(out *bytes.Buffer, n *Table, entering bool)
+	TableRow(out *bytes.Buffer, n *TableRow, entering bool)
+	TableCell(out *bytes.Buffer, n *TableCell, entering bool)
+	Footnote(out *bytes.Buffer, n *Footnote, entering bool)
+
+	// inline elements
+	Text(out *bytes.Buffer, n *Text)
+	LineBreak(out *bytes.Buffer, n *LineBreak)
+	// Phrase renders emphasis, strong, citations etc., see Phrase.Tag
+	Phrase(out *bytes.Buffer, n *Phrase, entering bool)
+	Span(out *bytes.Buffer, n *Span, entering bool)
+	CodeSpan(out *bytes.Buffer, n *Code)
+	Acronym(out *bytes.Buffer, n *Acronym)
+	Link(out *bytes.Buffer, n *Link, entering bool)
+	Image(out *bytes.Buffer, n *Image)
+	RawHTML(out *bytes.Buffer, n *RawHTML)
+	FootnoteRef(out *bytes.Buffer, n *FootnoteRef)
+}
+
+func renderChildren(out *bytes.Buffer, r Renderer, n Node) {
+	for _, c := range n.Children() {
+		renderNode(out, r, c)
+	}
+}
+
+// renderNode renders n and its children with r
+func renderNode(out *bytes.Buffer, r Renderer, n Node) {
+	switch n := n.(type) {
+	case *Paragraph:
+		r.Paragraph(out, n, true)
+		renderChildren(out, r, n)
+		r.Paragraph(out, n, false)
+	case *Heading:
+		r.Heading(out, n, true)
+		renderChildren(out, r, n)
+		r.Heading(out, n, false)
+	case *BlockQuote:
+		r.BlockQuote(out, n, true)
+		renderChildren(out, r, n)
+		r.BlockQuote(out, n, false)
+	case *List:
+		r.List(out, n, true)
+		renderChildren(out, r, n)
+		r.List(out, n, false)
+	case *ListItem:
+		r.ListItem(out, n, true)
+		renderChildren(out, r, n)
+		r.ListItem(out, n, false)
+	case *CodeBlock:
+		r.CodeBlock(out, n)
+	case *HTMLBlock:
+		r.HTMLBlock(out, n, true)
+		renderChildren(out, r, n)
+		r.HTMLBlock(out, n, false)
+	case *Table:
+		r.Table(out, n, true)
+		renderChildren(out, r, n)
+		r.Table(out, n, false)
+	case *TableRow:
+		r.TableRow(out, n, true)
+		renderChildren(out, r, n)
+		r.TableRow(out, n, false)
+	case *TableCell:
+		r.TableCell(out, n, true)
+		renderChildren(out, r, n)
+		r.TableCell(out, n, false)
+	case *Footnote:
+		r.Footnote(out, n, true)
+		renderChildren(out, r, n)
+		r.Footnote(out, n, false)
+	case *Text:
+		r.Text(out, n)
+	case *LineBreak:
+		r.LineBreak(out, n)
+	case *Phrase:
+		r.Phrase(out, n, true)
+		renderChildren(out, r, n)
+		r.Phrase(out, n, false)
+	case *Span:
+		r.Span(out, n, true)
+		renderChildren(out, r, n)
+		r.Span(out, n, false)
+	case *Code:
+		r.CodeSpan(out, n)
+	case *Acronym:
+		r.Acronym(out, n)
+	case *Link:
+		r.Link(out, n, true)
+		renderChildren(out, r, n)
+		r.Link(out, n, false)
+	case *Image:
+		r.Image(out, n)
+	case *RawHTML:
+		r.RawHTML(out, n)
+	case *FootnoteRef:
+		r.FootnoteRef(out, n)
+	default:
+		renderChildren(out, r, n)
+	}
+}
+
+// holdingRenderer is implemented by renderers that may change output they
+// rendered already, e.g. LatexRenderer fills in references to footnotes
+// once it renders them. Convert doesn't write the output while holding
+// returns true.
+type holdingRenderer interface {
+	holding() bool
+}
+
+// Render renders a document with r
+func Render(doc *Document, r Renderer) []byte {
+	out := new(bytes.Buffer)
+	r.DocumentHeader(out)
+	for i, n := range doc.Nodes {
+		if i > 0 {
+			r.BlockSeparator(out)
+		}
+		renderNode(out, r, n)
+	}
+	r.DocumentFooter(out)
+	return out.Bytes()
+}
@@ -0,0 +1,36 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type pictureRenderer struct {
+	Renderer
+}
+
+func (r *pictureRenderer) Image(out *bytes.Buffer, n *Image) {
+	out.WriteString(fmt.Sprintf(`<picture><source srcset="%s.webp">`, n.Src))
+	r.Renderer.Image(out, n)
+	out.WriteString("</picture>")
+}
+
+func TestWrapRenderer(t *testing.T) {
+	doc := Parse([]byte("A *cat*: !cat(Cat)!"))
+	got := string(Render(doc, &pictureRenderer{NewXhtmlRenderer()}))
+	exp := `	<p>A <strong>cat</strong>: <picture><source srcset="cat.webp"><img src="cat" title="Cat" alt="Cat" /></picture></p>`
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestRendererReuse(t *testing.T) {
+	r := NewHtmlRenderer()
+	doc := Parse([]byte("a[1]\n\nfn1. b"))
+	first := Render(doc, r)
+	second := Render(doc, r)
+	if !bytes.Equal(first, second) {
+		t.Fatalf("\nFirst: %#v\nSecond:%#v\n", string(first), string(second))
+	}
+}
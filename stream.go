@@ -0,0 +1,391 @@
+package textiler
+
+import (
+	"bytes"
+	"io"
+)
+
+type streamer struct {
+	p   *TextileParser
+	r   Renderer
+	w   io.Writer
+	out bytes.Buffer
+	// complete blocks that use references that aren't defined yet, or
+	// follow a {toc} placeholder. The first finished of them were
+	// post-processed already.
+	pending  []Node
+	finished int
+	// number of blocks written so far
+	written int
+	// number of bytes and lines read so far
+	size   int
+	lineNo int
+	// was there a line other than empty or reference line?
+	started bool
+	// was the last line empty?
+	lastEmpty bool
+	// lines read so far that may be front matter, see holdFrontMatter
+	front []streamLine
+	// is a line longer than Limits.MaxLineSize being read?
+	long bool
+}
+
+// streamLine is a line and the number of bytes read for it
+type streamLine struct {
+	l []byte
+	n int
+}
+
+// lineReader reads lines ending with cr, lf or crlf
+type lineReader struct {
+	r io.Reader
+	// maximum length of a line, longer lines are returned in parts; 0 if
+	// there's no limit
+	max int
+	// buf[start:end] was read but not returned yet
+	buf        []byte
+	start, end int
+	err        error
+}
+
+// fill reads more input into buf, growing it if it's full
+func (lr *lineReader) fill() {
+	if lr.start > 0 {
+		lr.end = copy(lr.buf, lr.buf[lr.start:lr.end])
+		lr.start = 0
+	}
+	if lr.end == len(lr.buf) {
+		buf := make([]byte, 2*len(lr.buf)+4096)
+		copy(buf, lr.buf[:lr.end])
+		lr.buf = buf
+	}
+	n, err := lr.r.Read(lr.buf[lr.end:])
+	lr.end += n
+	lr.err = err
+}
+
+// readLine returns the next line without the end-of-line character(s),
+// and the number of bytes read. A line longer than max bytes is returned
+// in parts of at most max bytes, cut between characters, with more set for
+// all but the last one. It returns io.EOF only if there's nothing left to
+// read.
+func (lr *lineReader) readLine() (line []byte, n int, more bool, err error) {
+	for {
+		b := lr.buf[lr.start:lr.end]
+		long := lr.max > 0 && len(b) > lr.max
+		if long {
+			b = b[:lr.max+1]
+		}
+		if i := bytes.IndexAny(b, "\r\n"); i != -1 {
+			n = i + 1
+			if b[i] == cr {
+				if lr.start+n == lr.end && lr.err == nil {
+					// see if lf follows
+					lr.fill()
+					continue
+				}
+				if lr.start+n < lr.end && lr.buf[lr.start+n] == lf {
+					n += 1
+				}
+			}
+			line = append([]byte{}, b[:i]...)
+			lr.start += n
+			return line, n, false, nil
+		}
+		if long {
+			n = cutRunes(b[:lr.max])
+			line = append([]byte{}, b[:n]...)
+			lr.start += n
+			return line, n, true, nil
+		}
+		if lr.err != nil {
+			if lr.err != io.EOF || len(b) == 0 {
+				return nil, 0, false, lr.err
+			}
+			line = append([]byte{}, b...)
+			lr.start = lr.end
+			return line, len(line), false, nil
+		}
+		lr.fill()
+	}
+}
+
+// isRefName returns true if url might be a name of a reference that's not
+// defined yet, rather than an url
+func isRefName(url string) bool {
+	if len(url) == 0 {
+		return false
+	}
+	for i := 0; i < len(url); i++ {
+		switch url[i] {
+		case ':', '/', '.', '#', '?', '&', '=', '@':
+			return false
+		}
+	}
+	return true
+}
+
+// resolveRefs looks up links in n that weren't resolved when n was parsed
+// and returns true if all of them are resolved now
+func (s *streamer) resolveRefs(n Node) bool {
+	resolved := true
+	Inspect(n, func(n Node) bool {
+		if l, ok := n.(*Link); ok && l.Ref == "" && !l.Wiki && isRefName(l.URL) {
+			if url, ok := s.p.lookupRef(l.URL); ok {
+				l.Ref = l.URL
+				l.URL = url
+			} else {
+				resolved = false
+			}
+		}
+		return true
+	})
+	return resolved
+}
+
+func (s *streamer) write(n Node, finished bool) error {
+	if !finished {
+		s.p.finishBlock(n)
+	}
+	if s.p.toc && isTOCPlaceholder(n) {
+		// the table of contents lists the headings of the blocks after
+		// it, which need their ids
+		for _, m := range s.pending[s.finished:] {
+			s.resolveRefs(m)
+			s.p.finishBlock(m)
+		}
+		s.finished = len(s.pending)
+		if n = tocFor(n, s.p.headings); n == nil {
+			return s.p.traceErr
+		}
+	}
+	if s.p.traceErr != nil {
+		return s.p.traceErr
+	}
+	if s.written > 0 {
+		s.r.BlockSeparator(&s.out)
+	}
+	s.written += 1
+	renderNode(&s.out, s.r, n)
+	if h, ok := s.r.(holdingRenderer); ok && h.holding() {
+		return nil
+	}
+	_, err := s.out.WriteTo(s.w)
+	return err
+}
+
+// flush writes pending blocks whose references are resolved. If force is
+// true, it writes all of them.
+func (s *streamer) flush(force bool) error {
+	for len(s.pending) > 0 {
+		n := s.pending[0]
+		tooMany := s.p.limits.MaxPendingBlocks > 0 && len(s.pending) > s.p.limits.MaxPendingBlocks
+		if !s.resolveRefs(n) && !force && !tooMany {
+			return nil
+		}
+		// a {toc} placeholder waits for the headings after it
+		if s.p.toc && isTOCPlaceholder(n) && !force && !tooMany {
+			return nil
+		}
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		finished := s.finished > 0
+		if finished {
+			s.finished -= 1
+		}
+		if err := s.write(n, finished); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeComplete moves blocks the parser is done with to pending
+func (s *streamer) takeComplete() {
+	doc := s.p.doc
+	n := len(doc.Nodes)
+	if !s.p.isIdle() {
+		n -= 1
+	}
+	if n <= 0 {
+		return
+	}
+	s.pending = append(s.pending, doc.Nodes[:n]...)
+	rest := copy(doc.Nodes, doc.Nodes[n:])
+	for i := rest; i < len(doc.Nodes); i++ {
+		doc.Nodes[i] = nil
+	}
+	doc.Nodes = doc.Nodes[:rest]
+}
+
+func (s *streamer) parseLine(l []byte, n int) {
+	p := s.p
+	s.lineNo += 1
+	p.setLine(l, s.size, s.lineNo)
+	s.size += n
+	if !p.plain && p.limits.MaxInputSize > 0 && s.size > p.limits.MaxInputSize {
+		p.startPlain(p.linePos())
+	}
+	p.tracef("'%s'\n", l)
+	if p.parseRef(l) {
+		return
+	}
+	// collapse multiple consecutive empty lines
+	empty := len(l) == 0
+	if empty && (s.lastEmpty || !s.started) {
+		return
+	}
+	s.started = true
+	s.lastEmpty = empty
+	p.parseBlock(l)
+}
+
+// parseLongLinePart parses l, a part of a line longer than
+// Limits.MaxLineSize, of which more parts follow if more is set
+func (s *streamer) parseLongLinePart(l []byte, n int, more bool) {
+	p := s.p
+	if !s.long {
+		s.lineNo += 1
+	}
+	p.setLine(l, s.size, s.lineNo)
+	s.size += n
+	p.startLongLine()
+	p.addLongLinePart(l)
+	s.long = more
+	s.started = true
+	s.lastEmpty = false
+}
+
+// holdFrontMatter holds back l if it may be a line of front matter and
+// returns true if it did. Once the --- line that ends the front matter is
+// read, the lines are parsed into the metadata of the document. If another
+// line comes first, they're parsed as textile by parseHeld.
+func (s *streamer) holdFrontMatter(l []byte, n int) bool {
+	if !s.p.frontMatter || s.size > 0 || s.front == nil && !isFrontMatterDelim(l) {
+		return false
+	}
+	if s.front == nil {
+		s.front = append(s.front, streamLine{l, n})
+		return true
+	}
+	if isFrontMatterDelim(l) {
+		lines := make([][]byte, 0, len(s.front)-1)
+		for _, h := range s.front[1:] {
+			lines = append(lines, h.l)
+		}
+		s.p.setMeta(lines)
+		for _, h := range append(s.front, streamLine{l, n}) {
+			s.lineNo += 1
+			s.size += h.n
+		}
+		s.front = nil
+		return true
+	}
+	held := n
+	for _, h := range s.front {
+		held += h.n
+	}
+	if _, _, ok := parseMetaLine(l); !ok || s.p.limits.MaxInputSize > 0 && held > s.p.limits.MaxInputSize {
+		return false
+	}
+	s.front = append(s.front, streamLine{l, n})
+	return true
+}
+
+// parseHeld parses the lines held back by holdFrontMatter as textile
+func (s *streamer) parseHeld() error {
+	front := s.front
+	s.front = nil
+	for _, h := range front {
+		if err := s.step(h.l, h.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step parses a line and writes the blocks that are complete
+func (s *streamer) step(l []byte, n int) error {
+	s.parseLine(l, n)
+	s.takeComplete()
+	return s.flush(false)
+}
+
+func (s *streamer) convert(r io.Reader) error {
+	lr := &lineReader{r: r, max: s.p.limits.MaxLineSize}
+	s.p.startDocument()
+	s.r.DocumentHeader(&s.out)
+	for {
+		l, n, more, err := lr.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if more || s.long {
+			if err := s.parseHeld(); err != nil {
+				return err
+			}
+			s.parseLongLinePart(l, n, more)
+			s.takeComplete()
+			if err := s.flush(false); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.holdFrontMatter(l, n) {
+			continue
+		}
+		if err := s.parseHeld(); err != nil {
+			return err
+		}
+		if err := s.step(l, n); err != nil {
+			return err
+		}
+	}
+	if err := s.parseHeld(); err != nil {
+		return err
+	}
+	s.p.endDocument()
+	s.takeComplete()
+	if err := s.flush(true); err != nil {
+		return err
+	}
+	s.r.DocumentFooter(&s.out)
+	_, err := s.out.WriteTo(s.w)
+	return err
+}
+
+// Convert reads textile from r and writes html to w. Unlike ToHtml, it
+// doesn't read the whole input up front: blocks are rendered and written
+// as soon as they are parsed, so memory use is bounded by the size of the
+// largest block rather than of the document. Lines longer than
+// Limits.MaxLineSize are read in parts and rendered as text, see Limits.
+//
+// References ([name]url lines) may follow links that use them. Blocks with
+// links to names that aren't defined yet are held back until the
+// definitions are read or, if there are more than Limits.MaxPendingBlocks
+// of them, written with the names used as urls. Limits.MaxInputSize
+// applies to the rest of the input once that much was read.
+//
+// Front matter (see Options.FrontMatter) is left out of the output, use
+// ConvertWithMeta to get it.
+//
+// Ids generated for headings (see Options.HeadingIDs) are only unique
+// among the headings before them: a heading can't see the explicit id of
+// a later one, which may repeat its id, unlike with ConvertBytes.
+//
+// Convert returns diagnostics found in the input, see ConvertBytes.
+func Convert(w io.Writer, r io.Reader, opts *Options) ([]Diagnostic, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	p := getParser(opts)
+	defer putParser(p)
+	s := &streamer{p: p, r: NewRenderer(opts), w: w}
+	if err := s.convert(r); err != nil {
+		return nil, err
+	}
+	return p.endDiagnostics(), nil
+}
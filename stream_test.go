@@ -0,0 +1,145 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func convertString(s string, opts *Options) (string, error) {
+	var buf bytes.Buffer
+	_, err := Convert(&buf, strings.NewReader(s), opts)
+	return buf.String(), err
+}
+
+func TestConvertMatchesToHtml(t *testing.T) {
+	for i := 0; i < len(HtmlTests)/2; i++ {
+		s := HtmlTests[i*2]
+		got, err := convertString(s, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := textileToHtml(s); got != exp {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, exp, got)
+		}
+	}
+	for i := 0; i < len(XhtmlTests)/2; i++ {
+		s := XhtmlTests[i*2]
+		got, err := convertString(s, &Options{Flavor: FlavorXhtml})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := textileToXhtml(s); got != exp {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, exp, got)
+		}
+	}
+}
+
+// checkConvertMatches checks that Convert gives the same output as
+// ConvertBytes for the corpus and tests
+func checkConvertMatches(t *testing.T, opts *Options, tests ...string) {
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	for i := 0; i < len(corpus); i += 2 {
+		tests = append(tests, corpus[i])
+	}
+	for _, s := range tests {
+		got, err := convertString(s, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := convertBytes(t, s, opts); got != exp {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, exp, got)
+		}
+	}
+}
+
+func TestConvertPendingRefs(t *testing.T) {
+	s := "\"a\":first\n\n\"b\":second\n\n[first]http://a.com\n\n[second]http://b.com"
+	exp := "\t<p><a href=\"first\">a</a></p>\n\n\t<p><a href=\"http://b.com\">b</a></p>"
+	got, err := convertString(s, &Options{Limits: Limits{MaxPendingBlocks: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != exp {
+		t.Fatalf("\nExp:%#v\nGot:%#v\n", exp, got)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+type firstWriteRecorder struct {
+	r *countingReader
+	// number of bytes read before the first write, -1 if no write yet
+	readBeforeWrite int
+}
+
+func (w *firstWriteRecorder) Write(p []byte) (int, error) {
+	if w.readBeforeWrite == -1 {
+		w.readBeforeWrite = w.r.n
+	}
+	return len(p), nil
+}
+
+func TestConvertIsIncremental(t *testing.T) {
+	var src bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&src, "Paragraph *%d* of a long document.\n\n", i)
+	}
+	r := &countingReader{r: &src}
+	n := src.Len()
+	w := &firstWriteRecorder{r: r, readBeforeWrite: -1}
+	if _, err := Convert(w, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w.readBeforeWrite == -1 || w.readBeforeWrite >= n {
+		t.Fatalf("output written after reading %d of %d bytes", w.readBeforeWrite, n)
+	}
+}
+
+func TestConvertLongLines(t *testing.T) {
+	opts := &Options{Limits: Limits{MaxLineSize: 8}}
+	checkConvertMatches(t, opts,
+		"*bold* and é\n\nh2. b",
+		"<div>\n0123456789\r\n</div>",
+		"0123456789abcdef\r\n\r\nh1. a",
+		"01234567\r\n01234567\r01234567",
+		"\"a\":x\n\n[x]http://example.com/long")
+	checkConvertMatches(t, &Options{FrontMatter: true, Limits: Limits{MaxLineSize: 8}},
+		"---\ntitle: 0123456789\n---\na", "---\na: b\n---\n0123456789")
+	for _, s := range []string{"a\n\n0123456789", "a\r\n01234567\r\n\r\nbq. é\r"} {
+		var buf bytes.Buffer
+		diags, err := Convert(&buf, iotest.OneByteReader(strings.NewReader(s)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, expDiags, _ := ConvertBytes([]byte(s), opts)
+		if buf.String() != string(exp) || fmt.Sprint(diags) != fmt.Sprint(expDiags) {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v %v\n\nGot:%#v %v\n", s, string(exp), expDiags, buf.String(), diags)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestConvertWriteError(t *testing.T) {
+	_, err := Convert(failingWriter{}, strings.NewReader("a\n\nb"), nil)
+	if err != io.ErrShortWrite {
+		t.Fatalf("expected %v, got %v", io.ErrShortWrite, err)
+	}
+}
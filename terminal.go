@@ -0,0 +1,185 @@
+package textiler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// TerminalRenderer renders text for terminals like TextRenderer, styled
+// with ANSI escapes: headings and strong phrases are bold, emphasized ones
+// italic, links underlined and followed by their urls and code blocks
+// dimmed. List items are indented and bulleted and tables are boxed.
+// Without color the escapes are left out.
+type TerminalRenderer struct {
+	*TextRenderer
+	color bool
+}
+
+func NewTerminalRenderer() *TerminalRenderer {
+	t := NewTextRenderer()
+	t.linkUrls = true
+	t.bullet = "• "
+	t.itemIndent = 2
+	return &TerminalRenderer{TextRenderer: t, color: true}
+}
+
+// RenderTerminal renders a document as text with ANSI escapes
+func RenderTerminal(doc *Document) []byte {
+	return Render(doc, NewTerminalRenderer())
+}
+
+// ansiPhrases are the ANSI codes that start and end styles of phrases
+var ansiPhrases = map[string][2]string{
+	"strong": {"1", "22"},
+	"b":      {"1", "22"},
+	"em":     {"3", "23"},
+	"i":      {"3", "23"},
+	"cite":   {"3", "23"},
+	"del":    {"9", "29"},
+	"ins":    {"4", "24"},
+}
+
+// style writes the ANSI escape of code if colors are on
+func (r *TerminalRenderer) style(out *bytes.Buffer, code string) {
+	if r.color {
+		out.WriteString("\x1b[" + code + "m")
+	}
+}
+
+func (r *TerminalRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		r.TextRenderer.Heading(out, n, entering)
+		r.style(out, "1")
+		return
+	}
+	r.style(out, "22")
+	r.TextRenderer.Heading(out, n, entering)
+}
+
+// CodeBlock dims each line of code, so that prefixes of the lines, e.g. of
+// block quotes, aren't
+func (r *TerminalRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	r.startBlock(out)
+	for i, l := range strings.Split(dropControls(strings.TrimRight(n.Text, "\n")), "\n") {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		if l != "" {
+			r.style(out, "2")
+			out.WriteString(l)
+			r.style(out, "22")
+		}
+	}
+	r.endBlock(out)
+}
+
+func (r *TerminalRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	r.TextRenderer.Table(out, n, entering)
+	if entering {
+		r.table.boxed = true
+	}
+}
+
+func (r *TerminalRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	if entering {
+		r.TextRenderer.TableCell(out, n, entering)
+		if n.Header {
+			r.style(out, "1")
+		}
+		return
+	}
+	if n.Header {
+		r.style(out, "22")
+	}
+	r.TextRenderer.TableCell(out, n, entering)
+}
+
+func (r *TerminalRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	codes, ok := ansiPhrases[n.Tag]
+	switch {
+	case !ok:
+	case entering:
+		r.style(out, codes[0])
+	default:
+		r.style(out, codes[1])
+	}
+}
+
+func (r *TerminalRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if n.Missing {
+		return
+	}
+	if entering {
+		r.style(out, "4")
+		return
+	}
+	r.style(out, "24")
+	r.TextRenderer.Link(out, n, entering)
+}
+
+// box returns the rows of t with lines around the cells and below the
+// header row
+func (t *textTable) box() string {
+	widths := t.widths(3)
+	if len(widths) == 0 {
+		return ""
+	}
+	// columns where the cells of each row start
+	starts := make([]map[int]bool, len(t.rows))
+	for i, row := range t.rows {
+		starts[i] = make(map[int]bool)
+		col := 0
+		for _, c := range row {
+			starts[i][col] = true
+			col += c.cols
+		}
+		for ; col < len(widths); col++ {
+			starts[i][col] = true
+		}
+	}
+	// rule returns a line between rows whose cells start at above and
+	// below, which are nil at the top and the bottom
+	rule := func(above, below map[int]bool, left, right string) string {
+		var buf strings.Builder
+		buf.WriteString(left)
+		for col, w := range widths {
+			if col > 0 {
+				switch {
+				case above[col] && below[col]:
+					buf.WriteString("┼")
+				case above[col]:
+					buf.WriteString("┴")
+				case below[col]:
+					buf.WriteString("┬")
+				default:
+					buf.WriteString("─")
+				}
+			}
+			buf.WriteString(strings.Repeat("─", w+2))
+		}
+		buf.WriteString(right)
+		return buf.String()
+	}
+	lines := []string{rule(nil, starts[0], "┌", "┐")}
+	for i, row := range t.rows {
+		var buf strings.Builder
+		col := 0
+		for _, c := range row {
+			w := 3 * (c.cols - 1)
+			for _, cw := range widths[col : col+c.cols] {
+				w += cw
+			}
+			buf.WriteString("│ " + c.text + strings.Repeat(" ", w-textWidth(c.text)+1))
+			col += c.cols
+		}
+		for ; col < len(widths); col++ {
+			buf.WriteString("│ " + strings.Repeat(" ", widths[col]+1))
+		}
+		lines = append(lines, buf.String()+"│")
+		if i == 0 && t.header {
+			lines = append(lines, rule(starts[0], starts[1], "├", "┤"))
+		}
+	}
+	lines = append(lines, rule(starts[len(starts)-1], nil, "└", "┘"))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,92 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTerminalCorpus(t *testing.T) {
+	checkGolden(t, "terminal.golden", renderCorpus(t, &Options{Flavor: FlavorTerminal, Glyphs: true, Wrap: 60}))
+}
+
+func TestTerminalNoColorCorpus(t *testing.T) {
+	checkGolden(t, "terminal-nocolor.golden", renderCorpus(t, &Options{Flavor: FlavorTerminal, Glyphs: true, NoColor: true}))
+}
+
+func TestTerminal(t *testing.T) {
+	tests := []string{
+		"h2. Release *notes*\n\nSome *strong* and _em_ text with \"a link\":http://x.com that is wrapped.",
+		"\x1b[1mRelease \x1b[1mnotes\x1b[22m\x1b[22m\n-------------\n\nSome \x1b[1mstrong\x1b[22m and \x1b[3mem\x1b[23m text with \x1b[4ma\nlink\x1b[24m [http://x.com] that is\nwrapped.",
+
+		"* one\n** nested item with a long text\n* two\n\n# a\n# b",
+		"  • one\n      • nested item with a\n        long text\n  • two\n\n  1. a\n  2. b",
+
+		"bc. code\n  indented",
+		"\x1b[2mcode\x1b[22m\n\x1b[2m  indented\x1b[22m",
+	}
+	opts := &Options{Flavor: FlavorTerminal, Wrap: 30}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
+func TestTerminalNoColor(t *testing.T) {
+	tests := []string{
+		"|_. name|_. value|\n|a|1|\n|\\2. spanning cell text|\n|/2. x|y|\n|z|",
+		"┌──────┬─────────────┐\n" +
+			"│ name │ value       │\n" +
+			"├──────┼─────────────┤\n" +
+			"│ a    │ 1           │\n" +
+			"│ spanning cell text │\n" +
+			"│ x    │ y           │\n" +
+			"│      │ z           │\n" +
+			"└──────┴─────────────┘",
+
+		"|a|b|\n|\\2. c|",
+		"┌───┬───┐\n│ a │ b │\n│ c     │\n└───────┘",
+
+		"Some *strong* \"link\":http://x.com.\n\nbc. code",
+		"Some strong link [http://x.com].\n\ncode",
+	}
+	opts := &Options{Flavor: FlavorTerminal, NoColor: true}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
+func TestTerminalConvert(t *testing.T) {
+	checkConvertMatches(t, &Options{Flavor: FlavorTerminal},
+		"a\n\nb",
+		"bc. code\n\nafter",
+		"see[1]\n\nfn1. note")
+}
+
+func TestControlCharacters(t *testing.T) {
+	input := "h2. \x1b]0;title\x07Heading\n\nSome \x1b[31mred\x1b[0m text, &#27;[2J, @\x1b[2J@ and \u009b1m" +
+		" \"link\":http://x.com/\x1b[K\n\nbc. code\x08\x08\x08\tx\r\ny"
+	var buf bytes.Buffer
+	for _, opts := range []struct {
+		name string
+		opts *Options
+	}{
+		{"text", &Options{Flavor: FlavorText}},
+		{"terminal", &Options{Flavor: FlavorTerminal}},
+		{"terminal-nocolor", &Options{Flavor: FlavorTerminal, NoColor: true}},
+	} {
+		got := convertBytes(t, input, opts.opts)
+		if opts.name != "terminal" {
+			for _, r := range got {
+				if r < ' ' && r != '\n' && r != '\t' || r >= 0x7f && r <= 0x9f {
+					t.Errorf("%s: control character %U in %q", opts.name, r, got)
+				}
+			}
+		}
+		fmt.Fprintf(&buf, "-- %s --\n%s\n\n", opts.name, got)
+	}
+	checkGolden(t, "controls.golden", buf.Bytes())
+}
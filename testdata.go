@@ -51,14 +51,14 @@ The main text of the
 page goes here and will
 stay to the left of the
 sidebar.`,
-	`	<p><div style="float:right;"></p>
+	`<div style="float:right;">
 
 	<h3>Sidebar</h3>
 
 	<p><a href="http://hobix.com/">Hobix</a><br>
 <a href="http://ruby-lang.org/">Ruby</a></p>
 
-	<p></div></p>
+</div>
 
 	<p>The main text of the<br>
 page goes here and will<br>
@@ -285,14 +285,14 @@ The main text of the
 page goes here and will
 stay to the left of the
 sidebar.`,
-	`	<p><div style="float:right;"></p>
+	`<div style="float:right;">
 
 	<h3>Sidebar</h3>
 
 	<p><a href="http://hobix.com/">Hobix</a><br />
 <a href="http://ruby-lang.org/">Ruby</a></p>
 
-	<p></div></p>
+</div>
 
 	<p>The main text of the<br />
 page goes here and will<br />
@@ -0,0 +1,570 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextRenderer renders the readable text of a document without markup,
+// e.g. for search indexes and previews. Headings are underlined, list
+// items have bullets or numbers, block quotes are prefixed with "> " and
+// tables are aligned in columns. Html is reduced to its text.
+type TextRenderer struct {
+	// width lines are wrapped at, 0 doesn't wrap them
+	width int
+	// write urls of links in brackets after their text
+	linkUrls   bool
+	lineBreaks LineBreaks
+	// marker of items of unordered lists and spaces before the markers of
+	// all items
+	bullet     string
+	itemIndent int
+	lists      []*mdList
+	// the document and the block quotes, items and footnotes we're in,
+	// whose content is prefixed when they end
+	contexts []textContext
+	// width of the prefixes of the blocks we're in
+	indent int
+	// offset in out where the text of the current paragraph, heading,
+	// footnote or list item starts, -1 outside of them
+	para  int
+	table *textTable
+}
+
+// textContext is the document or a block quote, item or footnote that
+// blocks are rendered in
+type textContext struct {
+	// offset in out where it starts
+	start int
+	// a block was rendered in it, the next one is separated from it
+	separate bool
+	// offsets in out where the separator of the current block and the
+	// block start
+	sepStart, blockStart int
+}
+
+type textTable struct {
+	rows [][]textCell
+	// the first row is a header row
+	header bool
+	// draw lines around the cells
+	boxed bool
+	// number of rows below the current one that cells span, by column
+	spans []int
+	// offset in out where the current cell starts
+	start int
+}
+
+type textCell struct {
+	text string
+	cols int
+}
+
+func NewTextRenderer() *TextRenderer {
+	return &TextRenderer{para: -1, bullet: "* ", contexts: []textContext{{}}}
+}
+
+// RenderText renders a document as plain text
+func RenderText(doc *Document) []byte {
+	return Render(doc, NewTextRenderer())
+}
+
+// textWidth returns the number of characters in s, not counting ANSI
+// escape sequences
+func textWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '[' {
+			i += 2
+			for i < len(s) && (s[i] < '@' || s[i] > '~') {
+				i++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// wrapText wraps lines of s longer than width at spaces, words longer than
+// width are left on their own line
+func wrapText(s string, width int) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		var buf strings.Builder
+		n := 0
+		for _, word := range strings.Fields(l) {
+			w := textWidth(word)
+			if n > 0 && n+1+w > width {
+				buf.WriteString("\n")
+				n = 0
+			} else if n > 0 {
+				buf.WriteString(" ")
+				n += 1
+			}
+			buf.WriteString(word)
+			n += w
+		}
+		lines[i] = buf.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textOfHtml returns the text of html, without tags and the content of
+// scripts and styles
+func textOfHtml(html string) string {
+	var buf strings.Builder
+	z := &htmlTokenizer{s: html}
+	skip := false
+	for {
+		t, ok := z.next()
+		if !ok {
+			return buf.String()
+		}
+		switch t.typ {
+		case htmlText:
+			if !skip {
+				buf.WriteString(t.text)
+			}
+		case htmlStartTag:
+			skip = t.tag == "script" || t.tag == "style"
+			if t.tag == "br" {
+				buf.WriteString("\n")
+			}
+		case htmlEndTag:
+			skip = false
+		}
+	}
+}
+
+// context returns the document or the block quote, item or footnote
+// we're in
+func (r *TextRenderer) context() *textContext {
+	return &r.contexts[len(r.contexts)-1]
+}
+
+// startBlock separates a block from the previous one in the block quote,
+// item or footnote it's in, or in the document
+func (r *TextRenderer) startBlock(out *bytes.Buffer) {
+	c := r.context()
+	c.sepStart = out.Len()
+	if c.separate {
+		out.WriteString("\n\n")
+	}
+	c.blockStart = out.Len()
+}
+
+// endBlock ends a block started with startBlock. The separator of a block
+// without text is removed, so that blocks of html without text, e.g., don't
+// leave empty lines.
+func (r *TextRenderer) endBlock(out *bytes.Buffer) {
+	c := r.context()
+	if out.Len() == c.blockStart {
+		out.Truncate(c.sepStart)
+		return
+	}
+	c.separate = true
+}
+
+func (r *TextRenderer) pushStart(out *bytes.Buffer, indent int) {
+	r.contexts = append(r.contexts, textContext{start: out.Len()})
+	r.indent += indent
+}
+
+// popStart removes what was rendered since the matching pushStart from out
+// and returns it
+func (r *TextRenderer) popStart(out *bytes.Buffer, indent int) string {
+	start := r.context().start
+	r.contexts = r.contexts[:len(r.contexts)-1]
+	r.indent -= indent
+	s := string(out.Bytes()[start:])
+	out.Truncate(start)
+	return s
+}
+
+func (r *TextRenderer) startPara(out *bytes.Buffer) {
+	r.para = out.Len()
+}
+
+// endPara wraps the text of the current paragraph and returns the width of
+// its longest line
+func (r *TextRenderer) endPara(out *bytes.Buffer) int {
+	if r.para == -1 {
+		return 0
+	}
+	s := string(out.Bytes()[r.para:])
+	out.Truncate(r.para)
+	r.para = -1
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	// a line break at the end doesn't separate the next block further
+	s = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if r.width > 0 {
+		width := r.width - r.indent
+		if width < 1 {
+			width = 1
+		}
+		s = wrapText(s, width)
+	}
+	out.WriteString(s)
+	max := 0
+	for _, l := range strings.Split(s, "\n") {
+		if w := textWidth(l); w > max {
+			max = w
+		}
+	}
+	return max
+}
+
+func (r *TextRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.lists = r.lists[:0]
+	r.contexts = append(r.contexts[:0], textContext{})
+	r.indent = 0
+	r.para = -1
+	r.table = nil
+}
+
+func (r *TextRenderer) DocumentFooter(out *bytes.Buffer) {
+}
+
+// BlockSeparator writes nothing, blocks are separated when they start so
+// that blocks without text, e.g. of html, don't leave empty lines
+func (r *TextRenderer) BlockSeparator(out *bytes.Buffer) {
+}
+
+func (r *TextRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if entering {
+		r.startBlock(out)
+		r.startPara(out)
+	} else {
+		r.endPara(out)
+		r.endBlock(out)
+	}
+}
+
+func (r *TextRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		r.startBlock(out)
+		r.startPara(out)
+		return
+	}
+	underline := "-"
+	if n.Level == 1 {
+		underline = "="
+	}
+	if w := r.endPara(out); w > 0 {
+		out.WriteString("\n" + strings.Repeat(underline, w))
+	}
+	r.endBlock(out)
+}
+
+func (r *TextRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	if entering {
+		r.startBlock(out)
+		r.pushStart(out, 2)
+		return
+	}
+	if s := r.popStart(out, 2); s != "" {
+		out.WriteString(prefixLines(s, "> ", "> "))
+	}
+	r.endBlock(out)
+}
+
+func (r *TextRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if !entering {
+		r.lists = r.lists[:len(r.lists)-1]
+		if len(r.lists) == 0 {
+			r.endBlock(out)
+		}
+		return
+	}
+	if len(r.lists) > 0 {
+		// a nested list starts on a new line of the item it's in
+		r.endPara(out)
+		out.WriteString("\n")
+	} else {
+		r.startBlock(out)
+	}
+	r.lists = append(r.lists, &mdList{ordered: n.Ordered})
+}
+
+// itemMarker returns the bullet or number of the current item of a list
+func (r *TextRenderer) itemMarker(list *mdList) string {
+	indent := strings.Repeat(" ", r.itemIndent)
+	if list.ordered {
+		return fmt.Sprintf("%s%d. ", indent, list.items)
+	}
+	return indent + r.bullet
+}
+
+func (r *TextRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	list := r.lists[len(r.lists)-1]
+	if entering {
+		if list.items > 0 {
+			out.WriteString("\n")
+		}
+		list.items += 1
+		r.pushStart(out, textWidth(r.itemMarker(list)))
+		r.startPara(out)
+		return
+	}
+	r.endPara(out)
+	marker := r.itemMarker(list)
+	w := textWidth(marker)
+	s := r.popStart(out, w)
+	out.WriteString(prefixLines(s, marker, strings.Repeat(" ", w)))
+}
+
+func (r *TextRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	r.startBlock(out)
+	out.WriteString(dropControls(strings.TrimRight(n.Text, "\n")))
+	r.endBlock(out)
+}
+
+// HTMLBlock writes the text in the block without the newlines around it
+func (r *TextRenderer) HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool) {
+	if entering {
+		r.pushStart(out, 0)
+		return
+	}
+	if s := strings.Trim(r.popStart(out, 0), "\n"); s != "" {
+		r.startBlock(out)
+		out.WriteString(s)
+		r.endBlock(out)
+	}
+}
+
+func (r *TextRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if entering {
+		r.startBlock(out)
+		r.table = &textTable{header: true}
+		return
+	}
+	t := r.table
+	r.table = nil
+	t.header = t.header && len(t.rows) > 1
+	out.WriteString(t.String())
+	r.endBlock(out)
+}
+
+func (r *TextRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	t := r.table
+	if entering {
+		t.rows = append(t.rows, nil)
+		return
+	}
+	// fill columns spanned by cells of earlier rows at the end of the row
+	for t.spanned() {
+	}
+}
+
+// spanned adds an empty cell to the current row if its next column is
+// spanned by a cell of an earlier row
+func (t *textTable) spanned() bool {
+	row := &t.rows[len(t.rows)-1]
+	col := 0
+	for _, c := range *row {
+		col += c.cols
+	}
+	if col >= len(t.spans) || t.spans[col] == 0 {
+		return false
+	}
+	t.spans[col] -= 1
+	*row = append(*row, textCell{cols: 1})
+	return true
+}
+
+func (r *TextRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	t := r.table
+	if entering {
+		for t.spanned() {
+		}
+		t.start = out.Len()
+		return
+	}
+	text := strings.Join(strings.Fields(string(out.Bytes()[t.start:])), " ")
+	out.Truncate(t.start)
+	row := &t.rows[len(t.rows)-1]
+	if len(t.rows) == 1 && !n.Header {
+		t.header = false
+	}
+	cell := textCell{text: text, cols: 1}
+	if n.Colspan > 1 {
+		cell.cols = n.Colspan
+	}
+	col := 0
+	for _, c := range *row {
+		col += c.cols
+	}
+	for len(t.spans) < col+cell.cols {
+		t.spans = append(t.spans, 0)
+	}
+	if n.Rowspan > 1 {
+		for i := col; i < col+cell.cols; i++ {
+			t.spans[i] = n.Rowspan - 1
+		}
+	}
+	*row = append(*row, cell)
+}
+
+// widths returns the widths of the columns of t, with sep characters
+// between them
+func (t *textTable) widths(sep int) []int {
+	var widths []int
+	// cells spanning one column first, then the others widen the last
+	// column they span if they don't fit
+	for _, spanning := range []bool{false, true} {
+		for _, row := range t.rows {
+			col := 0
+			for _, c := range row {
+				for len(widths) < col+c.cols {
+					widths = append(widths, 0)
+				}
+				if (c.cols > 1) == spanning {
+					w := sep * (c.cols - 1)
+					for _, cw := range widths[col : col+c.cols] {
+						w += cw
+					}
+					if extra := textWidth(c.text) - w; extra > 0 {
+						widths[col+c.cols-1] += extra
+					}
+				}
+				col += c.cols
+			}
+		}
+	}
+	return widths
+}
+
+// String returns the rows of t with cells aligned in columns, the header
+// row is underlined
+func (t *textTable) String() string {
+	if t.boxed {
+		return t.box()
+	}
+	widths := t.widths(2)
+	var lines []string
+	for i, row := range t.rows {
+		var buf strings.Builder
+		col := 0
+		for _, c := range row {
+			w := 2 * (c.cols - 1)
+			for _, cw := range widths[col : col+c.cols] {
+				w += cw
+			}
+			if col > 0 {
+				buf.WriteString("  ")
+			}
+			buf.WriteString(c.text + strings.Repeat(" ", w-textWidth(c.text)))
+			col += c.cols
+		}
+		lines = append(lines, strings.TrimRight(buf.String(), " "))
+		if i == 0 && t.header {
+			var rule []string
+			for _, w := range widths {
+				rule = append(rule, strings.Repeat("-", w))
+			}
+			lines = append(lines, strings.Join(rule, "  "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *TextRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	marker := "[" + dropControls(n.ID) + "] "
+	if entering {
+		r.startBlock(out)
+		r.pushStart(out, len(marker))
+		r.startPara(out)
+		return
+	}
+	r.endPara(out)
+	s := r.popStart(out, len(marker))
+	out.WriteString(prefixLines(s, marker, strings.Repeat(" ", len(marker))))
+	r.endBlock(out)
+}
+
+// dropControls removes control characters other than newlines and tabs
+// from s, so that a document can't move the cursor or change the style of
+// the terminal it's shown in
+func dropControls(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c < ' ' && c != '\n' && c != '\t' || c >= 0x7f && c <= 0x9f {
+			return -1
+		}
+		return c
+	}, s)
+}
+
+func (r *TextRenderer) Text(out *bytes.Buffer, n *Text) {
+	out.WriteString(dropControls(unescapeHtml(n.Text)))
+}
+
+func (r *TextRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	if r.lineBreaks == LineBreaksSpace || r.table != nil {
+		out.WriteString(" ")
+	} else {
+		out.WriteString("\n")
+	}
+}
+
+func (r *TextRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+}
+
+func (r *TextRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+}
+
+func (r *TextRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	out.WriteString(dropControls(n.Text))
+}
+
+func (r *TextRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	out.WriteString(dropControls(fmt.Sprintf("%s (%s)", n.Text, n.Title)))
+}
+
+// urlOf returns the url of a link or image in brackets if urls are written
+// and it isn't the text already
+func (r *TextRenderer) urlOf(url, text string) string {
+	if !r.linkUrls || url == "" || url == text {
+		return ""
+	}
+	return " [" + dropControls(url) + "]"
+}
+
+func (r *TextRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if !entering && !n.Missing {
+		out.WriteString(r.urlOf(n.URL, unescapeHtml(textOf(n))))
+	}
+}
+
+func (r *TextRenderer) Image(out *bytes.Buffer, n *Image) {
+	out.WriteString(dropControls(n.Alt))
+	if n.Alt != "" {
+		out.WriteString(r.urlOf(n.URL, n.Alt))
+	}
+}
+
+// RawHTML writes the text of html, as a block if it isn't in one
+func (r *TextRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	s := dropControls(textOfHtml(n.HTML))
+	if r.para != -1 || r.table != nil {
+		out.WriteString(s)
+		return
+	}
+	if s = strings.TrimSpace(s); s != "" {
+		r.startBlock(out)
+		out.WriteString(s)
+		r.endBlock(out)
+	}
+}
+
+func (r *TextRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	out.WriteString("[" + dropControls(n.ID) + "]")
+}
@@ -0,0 +1,45 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestTextCorpus(t *testing.T) {
+	checkGolden(t, "text.golden", renderCorpus(t, &Options{Flavor: FlavorText, Glyphs: true}))
+}
+
+func TestText(t *testing.T) {
+	tests := []string{
+		"h1. Title\n\nh2. Sub title\n\nSome *strong* and 'quoted' text with \"a link\":http://x.com and a long line that is wrapped.",
+		"Title\n=====\n\nSub title\n---------\n\nSome strong and ‘quoted’ text with a\nlink [http://x.com] and a long line that\nis wrapped.",
+
+		"* one\n** nested item with a long text that wraps around\n* two\n\n# a\n# b",
+		"* one\n  * nested item with a long text that\n    wraps around\n* two\n\n1. a\n2. b",
+
+		"bq. A quote that is long enough to wrap around.\n\nfn1. A footnote[1] that is long enough to wrap.",
+		"> A quote that is long enough to wrap\n> around.\n\n[1] A footnote[1] that is long enough to\n    wrap.",
+
+		"|_. name|_. value|\n|a|1|\n|\\2. spanning cell text|\n|/2. x|y|\n|z|",
+		"name  value\n----  ------------\na     1\nspanning cell text\nx     y\n      z",
+
+		"<div>\nhello <b>html</b>\n</div>\n\nnotextile. <p>raw <em>p</em><script>x</script></p>\n\nbc. code\n  indented",
+		"hello html\n\nraw p\n\ncode\n  indented",
+
+		"CSS(Cascading) and !img.png(Alt)!:http://i.com line\nbreak \"http://a.com\":http://a.com",
+		"CSS (Cascading) and Alt [http://i.com]\nline\nbreak http://a.com",
+	}
+	opts := &Options{Flavor: FlavorText, Glyphs: true, Wrap: 40, LinkUrls: true}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
+func TestTextConvert(t *testing.T) {
+	checkConvertMatches(t, &Options{Flavor: FlavorText},
+		"a\n\nb",
+		"see[1]\n\nfn1. note",
+		"a\n\n<div>\n\n</div>\n\nb",
+		"line break at the end\n\n* list\n\nbq. quote")
+}
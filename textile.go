@@ -3,6 +3,11 @@ package textiler
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -21,27 +26,101 @@ type UrlRef struct {
 	name []byte
 }
 
-type TextileRenderer struct {
-}
-
 type TextileParser struct {
 	flags int
 
 	refs map[string]*UrlRef
-
-	// are we inside <p> tag?
-	inP bool
-
-	out *bytes.Buffer
-
-	// if we're parsing <ol> list, this tells us current nesting level
-	olLevel int
-	ulLevel int
-
-	blockLineNo    int
-	blockTags      []string
-	dumpLines      bool
-	dumpParagraphs bool
+	// references shared by documents, not modified by the parser
+	globalRefs map[string]string
+	// names of references by their keys, see refKeys, and are the global
+	// references in it?
+	similarRefs   map[string]string
+	globalIndexed bool
+	// resolves links that references don't
+	linkResolver LinkResolver
+	// recognize [[Page]] links?
+	wikiLinks bool
+	// where sizes of images are read from and the hook called for images
+	imageRoot fs.FS
+	imageHook ImageHook
+
+	doc *Document
+
+	// block receiving lines of text (paragraph, heading etc.), nil if none
+	inl *Container
+	// paragraph being built
+	para *Paragraph
+	// lists being built, from the outermost to the current nesting level
+	lists []*List
+	table *Table
+	// bc. or pre. block being built
+	code *CodeBlock
+	// notextile. block being built
+	raw *RawHTML
+	// text of the code or notextile. block being built
+	literal bytes.Buffer
+	// <pre> or <code> block being built and the number of its lines
+	html      *HTMLBlock
+	htmlLines int
+	// html block of a line that starts with a tag like <div> and the tag,
+	// see addFlowHtml
+	flowHtml    *Container
+	flowHtmlTag string
+	// are we inside ###. comment?
+	comment bool
+	// custom block signatures and the custom block being built
+	blocks map[string]BlockFunc
+	custom *CustomBlock
+	// was the last line of an extended custom block empty?
+	customBlank bool
+	// custom phrase modifiers, their markers (longest first) and the first
+	// bytes of the markers
+	phrases       map[string]PhraseModifier
+	phraseMarkers []string
+	phraseStarts  [128]bool
+
+	blockLineNo int
+	blockTags   []string
+
+	// line being parsed, the offset of its start and its number
+	line      []byte
+	lineStart int
+	lineNo    int
+	diags     []Diagnostic
+	// html start tags that weren't closed yet
+	openTags []openTag
+	// line and code of the last limit diagnostic
+	limitLine int
+	limitCode string
+
+	// if not nil, receives lines and parsed blocks, for debugging
+	trace    io.Writer
+	traceErr error
+	// replace quotes, dashes etc. with typographic glyphs?
+	glyphs bool
+	// if true, html in the input is escaped and unsafe urls are dropped
+	restricted bool
+	// called for each parsed top-level block
+	blockHook func(n Node)
+	// leave checking links to the parser of the enclosing document, see
+	// CustomBlock.ParseContent
+	parentChecksRefs bool
+	// generates ids of headings, nil if they aren't generated
+	slugs *slugger
+	// replace {toc} paragraphs with the table of contents of headings?
+	toc      bool
+	headings []*Heading
+	// parse front matter? take the title from the first h1.?
+	frontMatter      bool
+	titleFromHeading bool
+
+	limits Limits
+	// current nesting depth of inline markup
+	depth int
+	// amount of work spent on inline markup of the current line
+	work int
+	// if true, the input is too large and is serialized as escaped text
+	plain bool
 }
 
 func tagSkipPre(tag string) bool {
@@ -103,9 +182,63 @@ func NewParser(flags int) *TextileParser {
 	return &TextileParser{
 		flags:     flags,
 		refs:      make(map[string]*UrlRef),
-		out:       new(bytes.Buffer),
 		blockTags: make([]string, 0),
-	}
+		limits:    DefaultLimits,
+	}
+}
+
+// maxLiteralCap is the capacity of the buffer for code blocks above which
+// Reset drops it, so that a parser doesn't hold on to a lot of memory after
+// a large document
+const maxLiteralCap = 64 << 10
+
+// Reset clears the state left by the last document, including its
+// references, keeping the flags, limits and allocated memory. Parse does
+// it before parsing, so calling Reset is only needed to release the last
+// document, e.g. before putting the parser into a pool.
+func (p *TextileParser) Reset() {
+	for name := range p.refs {
+		delete(p.refs, name)
+	}
+	for key := range p.similarRefs {
+		delete(p.similarRefs, key)
+	}
+	p.globalIndexed = false
+	p.doc = nil
+	if p.slugs != nil {
+		p.slugs.reset()
+	}
+	p.headings = nil
+	p.inl = nil
+	p.para = nil
+	for i := range p.lists {
+		p.lists[i] = nil
+	}
+	p.lists = p.lists[:0]
+	p.table = nil
+	p.code = nil
+	p.raw = nil
+	p.custom = nil
+	p.customBlank = false
+	if p.literal.Cap() > maxLiteralCap {
+		p.literal = bytes.Buffer{}
+	}
+	p.literal.Reset()
+	p.html = nil
+	p.htmlLines = 0
+	p.comment = false
+	p.blockLineNo = 0
+	p.blockTags = p.blockTags[:0]
+	p.setLine(nil, 0, 0)
+	// diagnostics are returned with the document, so they aren't reused
+	p.diags = nil
+	p.openTags = p.openTags[:0]
+	p.limitLine = 0
+	p.limitCode = ""
+	p.traceErr = nil
+	p.depth = 0
+	p.work = 0
+	p.plain = false
 }
 
 var punctAndSpace = []byte(".,\"'?!;:() \t")
@@ -126,7 +259,7 @@ func parseStartTag(l []byte) (rest, html, tag []byte) {
 	}
 	html = l[:htmlEnd+1]
 	// TODO: this is incorrect, space might be inside an attribute
-	tagEnd := bytes.IndexByte(l, ' ')
+	tagEnd := bytes.IndexByte(l[:htmlEnd], ' ')
 	if tagEnd == -1 {
 		tagEnd = htmlEnd
 	}
@@ -196,8 +329,6 @@ func splitIntoLines(d []byte) [][]byte {
 		}
 		res = append(res, l)
 	}
-	panic("")
-	return res
 }
 
 func parseHtml(l []byte) (rest, html, tag []byte, start bool) {
@@ -290,7 +421,8 @@ func byteConcat(b1, b2 []byte) []byte {
 	if b2 == nil {
 		return b1
 	}
-	return append(b1, b2...)
+	// b1 may be a part of the input, which must not be overwritten
+	return append(b1[:len(b1):len(b1)], b2...)
 }
 
 type PaddingInfo struct {
@@ -397,7 +529,7 @@ func extractStyleOpt(l []byte) (rest, styleOpt []byte) {
 			if l[i] == '}' {
 				rest, styleOpt = l[i+1:], l[1:i]
 				if !endsWithByte(styleOpt, ';') {
-					styleOpt = append(styleOpt, ';')
+					styleOpt = byteConcat(styleOpt, []byte{';'})
 				}
 				return rest, styleOpt
 			}
@@ -435,6 +567,10 @@ func isChar(c byte) bool {
 		(c >= 'A' && c <= 'Z')
 }
 
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
@@ -642,8 +778,35 @@ func parseNoTextile(l []byte) (rest []byte) {
 }
 
 // bq. $rest
-func parseBlockQuote(l []byte) (rest []byte) {
-	return startsWith(l, []byte("bq. "))
+// bq. $rest or bq.:$cite $rest
+func parseBlockQuote(l []byte) (rest, cite []byte) {
+	if rest = startsWith(l, []byte("bq.:")); rest != nil {
+		i := bytes.IndexByte(rest, ' ')
+		if i < 1 {
+			return nil, nil
+		}
+		return rest[i+1:], rest[:i]
+	}
+	return startsWith(l, []byte("bq. ")), nil
+}
+
+// $ACRONYM($title)$rest, where ACRONYM is at the end of before
+func parseAcronym(before, l []byte) (rest, acronym, title []byte) {
+	start := len(before)
+	for start > 0 && (isUpper(before[start-1]) || isDigit(before[start-1])) {
+		start -= 1
+	}
+	if len(before)-start < 2 || !isUpper(before[start]) {
+		return nil, nil, nil
+	}
+	if start > 0 && !isPunctOrSpace(before[start-1]) {
+		return nil, nil, nil
+	}
+	rest, title = extractInside(l, '(', ')')
+	if len(bytes.TrimSpace(title)) == 0 {
+		return nil, nil, nil
+	}
+	return rest, before[start:], title
 }
 
 // ###. $rest
@@ -651,9 +814,17 @@ func parseComment(l []byte) (rest []byte) {
 	return startsWith(l, []byte("###. "))
 }
 
-// pre.. $rest
+// pre.. $rest or pre. $rest
 func parsePre(l []byte) (rest []byte) {
-	return startsWith(l, []byte("pre.. "))
+	if rest = startsWith(l, []byte("pre.. ")); rest != nil {
+		return rest
+	}
+	return startsWith(l, []byte("pre. "))
+}
+
+// bc. $rest
+func parseBc(l []byte) (rest []byte) {
+	return startsWith(l, []byte("bc. "))
 }
 
 // p($classOpt){$styleOpt}[$langOpt]. $rest
@@ -669,216 +840,287 @@ func parseP(l []byte) (rest []byte, attrs *AttributesOpt) {
 	return l[2:], attrs
 }
 
-func needsHtmlCodeEscaping(b byte) []byte {
-	switch b {
-
-	/*	case '"':
-		return []byte("&quot;")*/
-	case '&':
-		return []byte("&amp;")
-	case '<':
-		return []byte("&lt;")
-	case '>':
-		return []byte("&gt;")
-	case '\'':
-		return []byte("&#8217;")
+func parseNumber(l []byte) (rest []byte, n int) {
+	i := 0
+	for i < len(l) && isDigit(l[i]) {
+		n = n*10 + int(l[i]-'0')
+		i++
 	}
-	return nil
+	if i == 0 {
+		return nil, 0
+	}
+	return l[i:], n
 }
 
-func (p *TextileParser) serHtmlRaw(before, htmlRaw, rest []byte) {
-	p.serEscaped(before)
-	p.out.Write(htmlRaw)
-	p.parseInline(rest)
+// fn${n}($classOpt){$styleOpt}[$langOpt]. $rest
+func parseFootnote(l []byte) (rest, id []byte, attrs *AttributesOpt) {
+	if !bytes.HasPrefix(l, []byte("fn")) {
+		return nil, nil, nil
+	}
+	l = l[2:]
+	rest, _ = parseNumber(l)
+	if rest == nil {
+		return nil, nil, nil
+	}
+	id = l[:len(l)-len(rest)]
+	rest, attrs = parseAttributesOpt(rest, false)
+	if len(rest) < 2 || rest[0] != '.' || rest[1] != ' ' {
+		return nil, nil, nil
+	}
+	return rest[2:], id, attrs
 }
 
-func needsEscaping(b byte) []byte {
-	switch b {
-	case '\'':
-		return []byte("&#8217;")
+// [$n]$rest
+func parseFootnoteRef(l []byte) (rest, id []byte) {
+	if !startsWithByte(l, '[', 3) {
+		return nil, nil
 	}
-	return nil
+	rest, _ = parseNumber(l[1:])
+	if rest == nil || !startsWithByte(rest, ']', 1) {
+		return nil, nil
+	}
+	return rest[1:], l[1 : len(l)-len(rest)]
 }
 
-func (p *TextileParser) serEscapedInContext(l []byte) {
-	if p.inHtmlCode() || p.inHtmlPre() {
-		p.serAsHtmlCode(l)
-	} else {
-		p.serEscaped(l)
+// table($classOpt){$styleOpt}[$langOpt].
+func parseTable(l []byte) (attrs *AttributesOpt, ok bool) {
+	l = startsWith(l, []byte("table"))
+	if l == nil {
+		return nil, false
+	}
+	l, attrs = parseAttributesOpt(l, false)
+	l = bytes.TrimRight(l, " \t")
+	if len(l) != 1 || l[0] != '.' {
+		return nil, false
 	}
+	return attrs, true
 }
 
-func (p *TextileParser) serEscaped(l []byte) {
-	for _, b := range l {
-		if esc := needsEscaping(b); esc != nil {
-			p.out.Write(esc)
-		} else {
-			p.out.WriteByte(b)
+// ($classOpt){$styleOpt}[$langOpt]. |$cell|$cell|
+func parseTableRow(l []byte) (cells [][]byte, attrs *AttributesOpt) {
+	if len(l) > 0 && l[0] != '|' {
+		l, attrs = parseAttributesOpt(l, false)
+		l = startsWith(l, []byte(". "))
+		if l == nil {
+			return nil, nil
 		}
 	}
+	l = bytes.TrimRight(l, " \t")
+	if !startsWithByte(l, '|', 2) || !endsWithByte(l, '|') {
+		return nil, nil
+	}
+	cells = bytes.Split(l[1:len(l)-1], []byte{'|'})
+	return cells, attrs
 }
 
-func (p *TextileParser) serAsHtmlCode(l []byte) {
-	for _, b := range l {
-		if esc := needsHtmlCodeEscaping(b); esc != nil {
-			p.out.Write(esc)
-		} else {
-			p.out.WriteByte(b)
-		}
-	}
+type cellInfo struct {
+	header  bool
+	colspan int
+	rowspan int
+	valign  []byte
 }
 
-// s is "$class[#$id]", we return ' class="$class" id="$id"'
-func serClassOrIdOpt(s []byte) string {
-	if s == nil || len(s) == 0 {
-		return ""
+// _\$colspan/$rowspan^~($classOpt){$styleOpt}[$langOpt]. $rest
+func parseTableCell(l []byte) (rest []byte, ci cellInfo, attrs *AttributesOpt) {
+	for len(l) > 0 {
+		n := len(l)
+		switch l[0] {
+		case '_':
+			ci.header = true
+			l = l[1:]
+		case '\\':
+			if rest, n := parseNumber(l[1:]); rest != nil {
+				l, ci.colspan = rest, n
+			}
+		case '/':
+			if rest, n := parseNumber(l[1:]); rest != nil {
+				l, ci.rowspan = rest, n
+			}
+		case '^':
+			ci.valign = []byte("vertical-align:top;")
+			l = l[1:]
+		case '~':
+			ci.valign = []byte("vertical-align:bottom;")
+			l = l[1:]
+		}
+		if n == len(l) {
+			break
+		}
 	}
-	idx := bytes.IndexByte(s, '#')
-	if -1 == idx {
-		return fmt.Sprintf(` class="%s"`, string(s))
+	l, attrs = parseAttributesOpt(l, false)
+	if rest = startsWith(l, []byte(". ")); rest == nil {
+		if len(l) != 1 || l[0] != '.' {
+			return nil, ci, nil
+		}
+		rest = l[1:]
 	}
-	if 0 == idx {
-		return fmt.Sprintf(` id="%s"`, string(s[1:]))
+	if attrs == nil {
+		attrs = &AttributesOpt{}
 	}
-	return fmt.Sprintf(` class="%s" id="%s"`, string(s[:idx]), string(s[idx+1:]))
+	attrs.style = byteConcat(attrs.style, ci.valign)
+	return rest, ci, attrs
 }
 
-func serStyleOpt(s []byte) string {
-	if s == nil || len(s) == 0 {
-		return ""
+// attributes converts attributes as parsed to their structured form,
+// splitting $class#$id
+func (attrs *AttributesOpt) attributes() Attributes {
+	var a Attributes
+	if attrs == nil {
+		return a
+	}
+	class := attrs.class
+	if idx := bytes.IndexByte(class, '#'); idx != -1 {
+		a.ID = string(class[idx+1:])
+		class = class[:idx]
 	}
-	s = prettyPrintStyle(s)
-	return fmt.Sprintf(` style="%s"`, string(s))
+	a.Class = string(class)
+	a.Style = string(attrs.style)
+	a.Lang = string(attrs.lang)
+	return a
 }
 
-func serLangOpt(s []byte) string {
-	if s == nil || len(s) == 0 {
-		return ""
+// offsetIn returns the offset of b in line, or -1 if b is not a part of it
+func offsetIn(line, b []byte) int {
+	if cap(line) == 0 || cap(b) == 0 {
+		return -1
+	}
+	// slices of the same array share its last element
+	if &line[:cap(line)][cap(line)-1] != &b[:cap(b)][cap(b)-1] {
+		return -1
 	}
-	return fmt.Sprintf(` lang="%s"`, string(s))
+	off := cap(line) - cap(b)
+	if off < 0 || off > len(line) {
+		return -1
+	}
+	return off
 }
 
-func serAttributesOpt(attrs *AttributesOpt) string {
-	if attrs == nil {
-		return ""
+// pos returns the position of b, which is a part of the line being parsed.
+// If it's not, it returns the position of the line.
+func (p *TextileParser) pos(b []byte) Pos {
+	off := offsetIn(p.line, b)
+	if off == -1 {
+		off = 0
 	}
-	s1 := serClassOrIdOpt(attrs.class)
-	s2 := serStyleOpt(attrs.style)
-	s3 := serLangOpt(attrs.lang)
-	return s1 + s2 + s3
+	return Pos{Offset: p.lineStart + off, Line: p.lineNo, Col: off + 1}
 }
 
-func (p *TextileParser) serTag(tag string, attrs *AttributesOpt, before, inside, rest []byte) {
-	p.serAsHtmlCode(before)
-	p.out.WriteString(fmt.Sprintf("<%s%s>", tag, serAttributesOpt(attrs)))
-	p.parseInline(inside)
-	p.out.WriteString(fmt.Sprintf("</%s>", tag))
-	p.parseInline(rest)
+// posAfter returns the position right after b
+func (p *TextileParser) posAfter(b []byte) Pos {
+	pos := p.pos(b)
+	pos.Offset += len(b)
+	pos.Col += len(b)
+	return pos
 }
 
-// TODO: change to serTag("span", ...) ?
-func (p *TextileParser) serSpan(before, inside []byte, attrs *AttributesOpt, rest []byte) {
-	p.serEscaped(before)
-	attrsStr := serAttributesOpt(attrs)
-	p.out.WriteString(fmt.Sprintf(`<span%s>`, attrsStr))
-	p.parseInline(inside)
-	p.out.WriteString("</span>")
-	p.parseInline(rest)
+func (p *TextileParser) linePos() Pos {
+	return p.pos(p.line)
 }
 
-func (p *TextileParser) serUrl(before, title, url, rest []byte) {
-	p.serEscaped(before)
-	p.out.WriteString(fmt.Sprintf(`<a href="%s">`, string(url)))
-	p.serEscaped(title)
-	p.out.WriteString("</a>")
-	p.parseInline(rest)
+// setLine sets the line being parsed, which starts at offset start of the
+// source and has number n
+func (p *TextileParser) setLine(l []byte, start, n int) {
+	p.line = l
+	p.lineStart = start
+	p.lineNo = n
 }
 
-func (p *TextileParser) serCode(before, inside, rest []byte) {
-	p.serEscaped(before)
-	p.out.WriteString(fmt.Sprintf(`<code>%s</code>`, string(inside)))
-	p.parseInline(rest)
+func (p *TextileParser) addText(c *Container, l []byte) {
+	if len(l) == 0 {
+		return
+	}
+	c.AppendChild(&Text{Leaf: Leaf{Pos: p.pos(l)}, Text: string(l)})
 }
 
-func (p *TextileParser) serImg(before []byte, imgSrc []byte, alt []byte, attrs *AttributesOpt, url []byte, rest []byte) {
-	p.serEscaped(before)
-	if len(url) > 0 {
-		p.out.WriteString(fmt.Sprintf(`<a href="%s" class="img">`, string(url)))
-	}
-	s := ""
-	if len(attrs.style) > 0 {
-		s += fmt.Sprintf(` style="%s"`, string(attrs.style))
-	}
-	if len(attrs.class) > 0 {
-		s += fmt.Sprintf(` class="%s"`, string(attrs.class))
-	}
-	if len(alt) > 0 {
-		s += fmt.Sprintf(` title="%s"`, string(alt))
+func (p *TextileParser) addRawHtml(c *Container, html []byte) {
+	c.AppendChild(&RawHTML{Leaf: Leaf{Pos: p.pos(html)}, HTML: string(html)})
+}
+
+func (p *TextileParser) addHtml(c *Container, html []byte, tag string, start bool) {
+	if p.html == nil {
+		p.checkTag(tag, start, p.pos(html))
+		p.addRawHtml(c, html)
+		return
 	}
-	s += fmt.Sprintf(` alt="%s"`, string(alt))
-	p.out.WriteString(fmt.Sprintf(`<img src="%s"%s`, string(imgSrc), s))
-	if p.isXhtml() {
-		p.out.WriteString(" />")
-	} else {
-		p.out.WriteString(">")
+	// inside <pre> and <code> only their own tags are html
+	if start && tagSkipPre(tag) {
+		p.pushBlockTag(tag)
+		p.checkTag(tag, start, p.pos(html))
+		p.addRawHtml(c, html)
+		return
 	}
-	if len(url) > 0 {
-		p.out.WriteString("</a>")
+	if !start && p.popBlockTag(tag) {
+		p.checkTag(tag, start, p.pos(html))
+		p.addRawHtml(c, html)
+		return
 	}
-	p.parseInline(rest)
+	p.addText(c, html)
 }
 
-func (p *TextileParser) serNoTextile(s []byte) {
-	p.out.Write(s)
+func (p *TextileParser) addPhrase(c *Container, tag string, attrs *AttributesOpt, before, inside []byte) {
+	p.addText(c, before)
+	n := &Phrase{Tag: tag, Attrs: attrs.attributes()}
+	n.Pos = p.posAfter(before)
+	c.AppendChild(n)
+	p.parseInline(&n.Container, inside)
 }
 
-func prettyPrintStyle(s []byte) []byte {
-	res := make([]byte, 0)
-	state := 0 // 0 - regular, 1 - after ';'
-	for _, b := range s {
-		if state == 0 {
-			res = append(res, b)
-			if b == ';' {
-				state = 1
-			}
-		} else {
-			if b != ' ' {
-				res = append(res, ' ')
-				res = append(res, b)
-				state = 0
-			}
-		}
+func (p *TextileParser) addSpan(c *Container, before, inside []byte, attrs *AttributesOpt) {
+	p.addText(c, before)
+	n := &Span{Attrs: attrs.attributes()}
+	n.Pos = p.posAfter(before)
+	c.AppendChild(n)
+	p.parseInline(&n.Container, inside)
+}
+
+func (p *TextileParser) addUrl(c *Container, before, title, urlOrRefName []byte) {
+	p.addText(c, before)
+	n := &Link{URL: string(urlOrRefName)}
+	n.Pos = p.posAfter(before)
+	if url, ok := p.lookupRef(n.URL); ok {
+		n.Ref = n.URL
+		n.URL = url
 	}
-	if !endsWithByte(res, ';') {
-		res = append(res, ';')
+	if p.restricted && !isSafeUrl(n.URL) {
+		p.parseInline(c, title)
+		return
 	}
-	return res
+	c.AppendChild(n)
+	p.parseInline(&n.Container, title)
 }
 
-func (p *TextileParser) serP(s []byte, attrs *AttributesOpt) {
-	attrsStr := serAttributesOpt(attrs)
-	p.out.WriteString(fmt.Sprintf("\t<p%s>", attrsStr))
-	p.parseInline(s)
-	p.out.WriteString("</p>")
+func (p *TextileParser) addCode(c *Container, before, inside []byte) {
+	p.addText(c, before)
+	c.AppendChild(&Code{Leaf: Leaf{Pos: p.posAfter(before)}, Text: string(inside)})
 }
 
-func (p *TextileParser) serPre(s []byte) {
-	p.out.WriteString(fmt.Sprintf("<pre>"))
-	p.parseInline(s)
-	p.out.WriteString(fmt.Sprintf("</pre>"))
+func (p *TextileParser) addImg(c *Container, before []byte, imgSrc []byte, alt []byte, attrs *AttributesOpt, url []byte) {
+	p.addText(c, before)
+	if p.restricted && !(isSafeUrl(string(imgSrc)) && isSafeUrl(string(url))) {
+		p.addText(c, alt)
+		return
+	}
+	c.AppendChild(&Image{
+		Leaf:  Leaf{Pos: p.posAfter(before)},
+		Src:   string(imgSrc),
+		Alt:   string(alt),
+		Attrs: attrs.attributes(),
+		URL:   string(url),
+	})
 }
 
-func (p *TextileParser) serBlockQuote(s []byte) {
-	p.out.WriteString("\t<blockquote>\n\t")
-	p.serP(s, nil)
-	p.out.WriteString("\n\t</blockquote>")
+func (p *TextileParser) addAcronym(c *Container, before, acronym, title []byte) {
+	p.addText(c, before)
+	c.AppendChild(&Acronym{
+		Leaf:  Leaf{Pos: p.posAfter(before)},
+		Text:  string(acronym),
+		Title: string(title),
+	})
 }
 
-func (p *TextileParser) serH(rest []byte, n int, attrs *AttributesOpt) {
-	s := serAttributesOpt(attrs)
-	p.out.WriteString(fmt.Sprintf("\t<h%d%s>", n, s))
-	p.out.Write(rest) // TODO: escape?
-	p.out.WriteString(fmt.Sprintf("</h%d>", n))
+func (p *TextileParser) addFootnoteRef(c *Container, before, id []byte) {
+	p.addText(c, before)
+	c.AppendChild(&FootnoteRef{Leaf: Leaf{Pos: p.posAfter(before)}, ID: string(id)})
 }
 
 func parseListEl(l []byte, r rune) (rest []byte, level int) {
@@ -897,58 +1139,104 @@ func parseListEl(l []byte, r rune) (rest []byte, level int) {
 		level += 1
 		l = l[size:]
 	}
-	return nil, 0
 }
 
-func (p *TextileParser) closeOlIfNecessary() {
-	for p.olLevel > 0 {
-		p.olLevel -= 1
-		// TODO: write me
-		p.out.WriteString("</li>\n")
-		p.out.WriteString("\t</ol>")
-	}
+func (p *TextileParser) closeLists() {
+	p.lists = p.lists[:0]
 }
 
-func (p *TextileParser) closeUlIfNecessary() {
-	for p.ulLevel > 0 {
-		p.ulLevel -= 1
-		// TODO: if it's only one list element, it should be:
-		// </li></ul>, if more than one: </li>\n\t</ul>
-		p.out.WriteString("</li>\n")
-		p.out.WriteString("\t</ul>")
+// closeBlock ends the block being built (if any), the next line starts a
+// new one
+func (p *TextileParser) closeBlock() {
+	if p.code != nil {
+		p.code.Text = p.literal.String()
 	}
-}
-
-func (p *TextileParser) serOl(l []byte, level int) {
-	if p.olLevel > 0 {
-		p.out.WriteString("</li>\n")
+	if p.raw != nil {
+		p.raw.HTML = p.literal.String()
 	}
-	if level > p.olLevel {
-		n := level - p.olLevel
-		for n > 0 {
-			p.out.WriteString("\t<ol>\n")
-			n -= 1
-		}
+	if p.custom != nil {
+		p.endCustomBlock()
 	}
-	p.olLevel = level
-	p.out.WriteString("\t\t<li>")
-	p.parseInline(l)
+	p.literal.Reset()
+	p.closeLists()
+	p.inl = nil
+	p.para = nil
+	p.table = nil
+	p.code = nil
+	p.raw = nil
+	p.custom = nil
+	p.customBlank = false
+	p.comment = false
+}
+
+func (p *TextileParser) addBlock(n Node) {
+	p.doc.AppendChild(n)
 }
 
-func (p *TextileParser) serUl(l []byte, level int) {
-	if p.ulLevel > 0 {
-		p.out.WriteString("</li>\n")
+func (p *TextileParser) addListItem(l []byte, level int, ordered bool) {
+	if len(p.lists) > 0 && level == 1 && p.lists[0].Ordered != ordered {
+		p.closeLists()
+	}
+	if len(p.lists) == 0 {
+		list := &List{Ordered: ordered}
+		list.Pos = p.linePos()
+		p.closeBlock()
+		p.addBlock(list)
+		p.lists = append(p.lists, list)
 	}
-	if level > p.ulLevel {
-		n := level - p.ulLevel
-		for n > 0 {
-			p.out.WriteString("\t<ul>\n")
-			n -= 1
+	for level > len(p.lists) {
+		parent := p.lists[len(p.lists)-1]
+		item, _ := parent.lastChild().(*ListItem)
+		if item == nil {
+			item = &ListItem{}
+			item.Pos = p.linePos()
+			parent.AppendChild(item)
+		}
+		list := &List{Ordered: ordered}
+		list.Pos = p.linePos()
+		item.AppendChild(list)
+		p.lists = append(p.lists, list)
+	}
+	p.lists = p.lists[:level]
+	list := p.lists[level-1]
+	if list.Ordered != ordered {
+		// a nested list of a different kind
+		parent := p.lists[level-2]
+		item := parent.lastChild().(*ListItem)
+		list = &List{Ordered: ordered}
+		list.Pos = p.linePos()
+		item.AppendChild(list)
+		p.lists[level-1] = list
+	}
+	item := &ListItem{}
+	item.Pos = p.linePos()
+	list.AppendChild(item)
+	p.parseInline(&item.Container, l)
+}
+
+func (p *TextileParser) addTableRow(cells [][]byte, attrs *AttributesOpt) {
+	if p.table == nil {
+		p.closeBlock()
+		p.table = &Table{}
+		p.table.Pos = p.linePos()
+		p.addBlock(p.table)
+	}
+	row := &TableRow{Attrs: attrs.attributes()}
+	row.Pos = p.linePos()
+	p.table.AppendChild(row)
+	for _, l := range cells {
+		cell := &TableCell{}
+		cell.Pos = p.pos(l)
+		if rest, ci, attrs := parseTableCell(l); rest != nil {
+			l = rest
+			cell.Header = ci.header
+			cell.Colspan = ci.colspan
+			cell.Rowspan = ci.rowspan
+			cell.Attrs = attrs.attributes()
 		}
+		row.AppendChild(cell)
+		p.parseInline(&cell.Container, l)
 	}
-	p.ulLevel = level
-	p.out.WriteString("\t\t<li>")
-	p.parseInline(l)
 }
 
 // find qtag (or two) followed by punctuation
@@ -980,34 +1268,40 @@ func parseQtagInside(l []byte, qtag byte, two bool) (rest, inside []byte) {
 	return nil, nil
 }
 
-func (p *TextileParser) parseQtag(before, rest []byte, qtag byte, tag string) bool {
+func (p *TextileParser) parseQtag(c *Container, before, rest []byte, qtag byte, tag string) []byte {
 	if !endsWithPunctOrSpace(before) {
-		return false
+		return nil
 	}
 	rest = rest[1:] // we know the first byte is qtag
 	rest, attrs := parseAttributesOpt(rest, false)
-	if rest, inside := parseQtagInside(rest, qtag, false); rest != nil {
-		p.serTag(tag, attrs, before, inside, rest)
-		return true
+	after, inside := parseQtagInside(rest, qtag, false)
+	if after == nil {
+		p.spend(len(rest))
+		return nil
 	}
-	return false
+	p.spend(len(inside))
+	p.addPhrase(c, tag, attrs, before, inside)
+	return after
 }
 
-func (p *TextileParser) parseQtag2(before, rest []byte, qtag byte, tag string) bool {
+func (p *TextileParser) parseQtag2(c *Container, before, rest []byte, qtag byte, tag string) []byte {
 	if !endsWithPunctOrSpace(before) {
-		return false
+		return nil
 	}
 	rest = rest[1:] // we know the first byte is qtag
 	if !startsWithByte(rest, qtag, 1) {
-		return false
+		return nil
 	}
 	rest = rest[1:]
 	rest, attrs := parseAttributesOpt(rest, false)
-	if rest, inside := parseQtagInside(rest, qtag, true); rest != nil {
-		p.serTag(tag, attrs, before, inside, rest)
-		return true
+	after, inside := parseQtagInside(rest, qtag, true)
+	if after == nil {
+		p.spend(len(rest))
+		return nil
 	}
-	return false
+	p.spend(len(inside))
+	p.addPhrase(c, tag, attrs, before, inside)
+	return after
 }
 
 var qtagToTag = map[byte]string{
@@ -1017,104 +1311,223 @@ var qtagToTag = map[byte]string{
 	'~': "sub",
 }
 
-func (p *TextileParser) parseInline(l []byte) {
+// parseInline parses l, recognizing inline markup, and adds the resulting
+// nodes to c. Constructs that follow each other on a line are handled
+// iteratively, so only markup nested inside other markup adds to the
+// recursion depth.
+func (p *TextileParser) parseInline(c *Container, l []byte) {
+	if p.plain {
+		p.addText(c, l)
+		return
+	}
+	if p.limits.MaxNestingDepth > 0 && p.depth >= p.limits.MaxNestingDepth {
+		p.reportLimit(p.pos(l), DiagNestingTooDeep, "markup is nested deeper than %d levels", p.limits.MaxNestingDepth)
+		p.addText(c, l)
+		return
+	}
+	p.depth += 1
+	for l != nil {
+		l = p.parseInlineOnce(c, l)
+	}
+	p.depth -= 1
+}
+
+// parseInlineOnce parses l up to and including the first inline construct
+// and returns what is left to parse, or nil if it consumed all of l.
+func (p *TextileParser) parseInlineOnce(c *Container, l []byte) (rest []byte) {
 	for i := 0; i < len(l); i++ {
 		b := l[i]
+		custom := p.isPhraseStart(b)
+		if (isInlineStart(b) || custom) && p.overBudget() {
+			p.reportLimit(p.pos(l[i:]), DiagLineTooComplex, "line needs too much work to parse, the rest of it is text")
+			p.addText(c, l)
+			return nil
+		}
+		if custom {
+			if rest := p.parseCustomPhrase(c, l[:i], l[i:]); rest != nil {
+				return rest
+			}
+		}
 
 		switch b {
 		case '-', '+', '^', '~':
-			if p.parseQtag(l[:i], l[i:], b, qtagToTag[b]) {
-				return
+			if rest := p.parseQtag(c, l[:i], l[i:], b, qtagToTag[b]); rest != nil {
+				return rest
 			}
 
 		case '?':
-			if p.parseQtag2(l[:i], l[i:], b, "cite") {
-				return
+			if rest := p.parseQtag2(c, l[:i], l[i:], b, "cite"); rest != nil {
+				return rest
 			}
 
 		case '_':
-			if p.parseQtag2(l[:i], l[i:], b, "i") {
-				return
+			if rest := p.parseQtag2(c, l[:i], l[i:], b, "i"); rest != nil {
+				return rest
 			}
-			if p.parseQtag(l[:i], l[i:], b, "em") {
-				return
+			if rest := p.parseQtag(c, l[:i], l[i:], b, "em"); rest != nil {
+				return rest
 			}
 
 		case '*':
-			if p.parseQtag2(l[:i], l[i:], b, "b") {
-				return
+			if rest := p.parseQtag2(c, l[:i], l[i:], b, "b"); rest != nil {
+				return rest
 			}
-			if p.parseQtag(l[:i], l[i:], b, "strong") {
-				return
+			if rest := p.parseQtag(c, l[:i], l[i:], b, "strong"); rest != nil {
+				return rest
 			}
 
 		case '"':
+			p.spend(scanCost(l[i+1:], '"'))
 			if rest, title, urlOrRefName := parseUrlOrRefName(l[i:]); rest != nil {
-				url := urlOrRefName
-				if urlRef, ok := p.refs[string(urlOrRefName)]; ok {
-					url = urlRef.url
-				}
-				p.serUrl(l[:i], title, url, rest)
-				return
+				p.addUrl(c, l[:i], title, urlOrRefName)
+				return rest
 			}
 
 		case '!':
+			p.spend(scanCost(l[i+1:], '!') + attrsScanCost(l[i+1:]))
 			if rest, url, imgSrc, alt, attrs := parseImg(l[i:]); rest != nil {
-				p.serImg(l[:i], imgSrc, alt, attrs, url, rest)
-				return
+				p.addImg(c, l[:i], imgSrc, alt, attrs, url)
+				return rest
 			}
 
 		case '@':
+			p.spend(scanCost(l[i+1:], '@'))
 			if rest, inside := parseCode(l[i:]); rest != nil {
-				p.serCode(l[:i], inside, rest)
-				return
+				p.addCode(c, l[:i], inside)
+				return rest
 			}
 
 		case '%':
+			p.spend(scanCost(l[i+1:], '%') + attrsScanCost(l[i+1:]))
 			if rest, inside, attrs := parseSpan(l[i:]); rest != nil {
-				p.serSpan(l[:i], inside, attrs, rest)
-				return
+				p.addSpan(c, l[:i], inside, attrs)
+				return rest
+			}
+			if (i == 0 || isPunctOrSpace(l[i-1])) && i+1 < len(l) && l[i+1] != ' ' {
+				p.report(p.pos(l[i:]), SeverityWarning, DiagUnclosedSpan, "%% is not closed")
+			}
+
+		case '[':
+			if p.wikiLinks {
+				p.spend(scanCost(l[i+1:], ']'))
+				if rest, page, text := parseWikiLink(l[i:]); rest != nil {
+					p.addWikiLink(c, l[:i], page, text)
+					return rest
+				}
+			}
+			if i > 0 && l[i-1] != ' ' {
+				if rest, id := parseFootnoteRef(l[i:]); rest != nil {
+					p.addFootnoteRef(c, l[:i], id)
+					return rest
+				}
+			}
+
+		case '(':
+			p.spend(scanCost(l[i+1:], ')'))
+			if rest, acronym, title := parseAcronym(l[:i], l[i:]); rest != nil {
+				p.addAcronym(c, l[:i-len(acronym)], acronym, title)
+				return rest
 			}
 
 		case '<':
-			if rest, html, _, _ := parseHtml(l[i:]); rest != nil {
-				p.parseInline(l[:i])
-				p.serEscapedInContext(html)
-				p.parseInline(rest)
-				return
+			if p.restricted {
+				continue
+			}
+			p.spend(scanCost(l[i+1:], '>'))
+			if rest, html, tag, start := parseHtml(l[i:]); rest != nil {
+				if isFlowTag(string(tag)) && !p.allowsFlowTag(c, string(tag), start) {
+					// text can't hold flow content, e.g. <div> inside <p>
+					continue
+				}
+				p.parseInline(c, l[:i])
+				p.addHtml(c, html, string(tag), start)
+				return rest
 			}
 		}
 	}
-	p.serAsHtmlCode(l)
-	//p.serEscapedInContext(l)
-	//p.serEscaped(l)
+	p.addText(c, l)
+	return nil
 }
 
+// startNewLine prepares the block being built for the next line of text,
+// starting a new paragraph if necessary
 func (p *TextileParser) startNewLine() {
-	if p.inHtmlBlock() {
-		if p.blockLineNo > 1 {
-			p.out.WriteString("\n")
+	if p.html != nil {
+		if p.htmlLines > 0 {
+			p.addText(p.inl, newline)
 		}
+		p.htmlLines += 1
 		return
 	}
-	if !p.inP {
-		p.out.WriteString("\t<p>")
-		p.inP = true
+	if p.inl == nil {
+		p.para = &Paragraph{}
+		p.para.Pos = p.linePos()
+		p.addBlock(p.para)
+		p.inl = &p.para.Container
 	} else {
-		if p.isXhtml() {
-			p.out.WriteString("<br />\n")
-		} else {
-			p.out.WriteString("<br>\n")
-		}
+		p.inl.AppendChild(&LineBreak{Leaf: Leaf{Pos: p.linePos()}})
 	}
 }
 
-func (p *TextileParser) closeP() {
-	if p.inP {
-		p.out.WriteString("</p>")
-		p.inP = false
+// addFlowHtml adds a line that starts with an html tag that can't be inside
+// a paragraph (e.g. <div>) as a block of its own, closing the paragraph
+// being built
+func (p *TextileParser) addFlowHtml(html []byte, tag string, startTag bool, rest []byte) {
+	p.closeBlock()
+	b := &HTMLBlock{}
+	b.Pos = p.linePos()
+	p.addBlock(b)
+	p.checkTag(tag, startTag, p.pos(html))
+	p.addRawHtml(&b.Container, html)
+	p.flowHtml, p.flowHtmlTag = &b.Container, tag
+	p.parseInline(&b.Container, rest)
+	p.flowHtml, p.flowHtmlTag = nil, ""
+}
+
+// isFlowTag returns true if tag is an element that can't be inside a
+// paragraph
+func isFlowTag(tag string) bool {
+	return flowTags[tag] || tag == "pre" || tag == "hr"
+}
+
+// allowsFlowTag returns true if the start or end tag of a flow element may
+// be added to c as html: c is the html block of a line that starts with a
+// tag like <div> that can hold flow content, or tag ends the element the
+// block of c starts with
+func (p *TextileParser) allowsFlowTag(c *Container, tag string, start bool) bool {
+	if c == p.flowHtml {
+		return !start && tag == p.flowHtmlTag || !phrasingTags[p.flowHtmlTag]
+	}
+	return !start && p.html != nil && c == &p.html.Container && p.lastBlockTagIs(tag)
+}
+
+// phrasingTags are flow elements that can only hold text and inline
+// elements
+var phrasingTags = map[string]bool{
+	"p":   true,
+	"h1":  true,
+	"h2":  true,
+	"h3":  true,
+	"h4":  true,
+	"h5":  true,
+	"h6":  true,
+	"pre": true,
+}
+
+func (p *TextileParser) startHtmlBlock() {
+	p.closeBlock()
+	p.html = &HTMLBlock{}
+	p.html.Pos = p.linePos()
+	p.htmlLines = 0
+	p.addBlock(p.html)
+	p.inl = &p.html.Container
+}
+
+func (p *TextileParser) endHtmlBlockIfClosed() {
+	if p.html != nil && !p.inHtmlBlock() {
+		p.html = nil
+		p.inl = nil
 	}
-	p.out.WriteString("\n\n")
 }
 
 func (p *TextileParser) parseBlockStart(l []byte) (parsed bool) {
@@ -1122,94 +1535,198 @@ func (p *TextileParser) parseBlockStart(l []byte) (parsed bool) {
 	if rune == utf8.RuneError {
 		return false
 	}
+	if p.html != nil && rune != '<' {
+		return false
+	}
+	if p.startCustomBlock(l) {
+		return true
+	}
 	parsed = true
 	switch rune {
 	case 'h':
 		if rest, n, attrs := parseH(l); n != -1 {
-			p.serH(rest, n, attrs)
+			p.closeBlock()
+			h := &Heading{Level: n, Attrs: attrs.attributes()}
+			h.Pos = p.linePos()
+			p.addBlock(h)
+			p.inl = &h.Container
+			p.parseInline(p.inl, rest)
 			return
 		}
 	case '<':
+		if p.restricted {
+			return false
+		}
 		if rest, html, tag, startTag := parseHtml(l); rest != nil {
 			tagStr := string(tag)
-			if startTag {
+			// <pre> starts an html block of its own, see startHtmlBlock
+			if p.html == nil && isFlowTag(tagStr) && (tagStr != "pre" || !startTag) {
+				p.addFlowHtml(html, tagStr, startTag, rest)
+				return
+			}
+			p.closeLists()
+			if startTag && p.html == nil {
+				if p.pushBlockTag(tagStr); p.inHtmlBlock() {
+					p.startHtmlBlock()
+				}
+			} else if startTag {
 				p.pushBlockTag(tagStr)
 			}
 			p.startNewLine()
-			p.serHtmlRaw(nil, html, rest)
+			p.checkTag(tagStr, startTag, p.pos(html))
+			p.addRawHtml(p.inl, html)
 			if !startTag {
 				p.popBlockTag(tagStr)
 			}
+			p.parseInline(p.inl, rest)
+			p.endHtmlBlockIfClosed()
 			return
 		}
 	case 'n':
-		if rest := parseNoTextile(l); rest != nil {
-			p.serNoTextile(rest)
+		if rest := parseNoTextile(l); rest != nil && !p.restricted {
+			p.closeBlock()
+			p.raw = &RawHTML{}
+			p.raw.Pos = p.linePos()
+			p.literal.Write(rest)
+			p.addBlock(p.raw)
 			return
 		}
 	case 'p':
 		if rest := parsePre(l); rest != nil {
-			p.serPre(rest)
+			p.closeBlock()
+			p.code = &CodeBlock{Preformatted: true}
+			p.code.Pos = p.linePos()
+			p.literal.Write(rest)
+			p.addBlock(p.code)
 			return
 		}
 		if rest, attrs := parseP(l); rest != nil {
-			p.serP(rest, attrs)
+			p.closeBlock()
+			p.para = &Paragraph{Attrs: attrs.attributes()}
+			p.para.Pos = p.linePos()
+			p.addBlock(p.para)
+			p.inl = &p.para.Container
+			p.parseInline(p.inl, rest)
 			return
 		}
 	case 'b':
-		if rest := parseBlockQuote(l); rest != nil {
-			p.serBlockQuote(rest)
+		if rest, cite := parseBlockQuote(l); rest != nil {
+			p.closeBlock()
+			bq := &BlockQuote{Cite: string(cite)}
+			if p.restricted && !isSafeUrl(bq.Cite) {
+				bq.Cite = ""
+			}
+			bq.Pos = p.linePos()
+			para := &Paragraph{}
+			para.Pos = p.linePos()
+			bq.AppendChild(para)
+			p.addBlock(bq)
+			p.inl = &para.Container
+			p.parseInline(p.inl, rest)
+			return
+		}
+		if rest := parseBc(l); rest != nil {
+			p.closeBlock()
+			p.code = &CodeBlock{}
+			p.code.Pos = p.linePos()
+			p.literal.Write(rest)
+			p.addBlock(p.code)
+			return
+		}
+	case 'f':
+		if rest, id, attrs := parseFootnote(l); rest != nil {
+			p.closeBlock()
+			fn := &Footnote{ID: string(id), Attrs: attrs.attributes()}
+			fn.Pos = p.linePos()
+			p.addBlock(fn)
+			p.inl = &fn.Container
+			p.parseInline(p.inl, rest)
+			return
+		}
+	case 't':
+		if attrs, ok := parseTable(l); ok {
+			p.closeBlock()
+			p.table = &Table{Attrs: attrs.attributes()}
+			p.table.Pos = p.linePos()
+			p.addBlock(p.table)
+			return
+		}
+	case '|', '(':
+		if cells, attrs := parseTableRow(l); cells != nil {
+			p.addTableRow(cells, attrs)
 			return
 		}
 	case '#':
 		// TODO: not fully correct
 		if rest := parseComment(l); rest != nil {
+			p.closeBlock()
+			p.comment = true
 			return
 		}
 		if rest, level := parseListEl(l, '#'); rest != nil {
-			p.serOl(rest, level)
+			p.addListItem(rest, level, true)
 			return
 		}
 	case '*':
 		if rest, level := parseListEl(l, '*'); rest != nil {
-			p.serUl(rest, level)
+			p.addListItem(rest, level, false)
 			return
 		}
 	case '•':
 		if rest, level := parseListEl(l, '•'); rest != nil {
-			p.serUl(rest, level)
+			p.addListItem(rest, level, false)
 			return
 		}
 	}
 	return false
 }
 
-func (p *TextileParser) closePrevBlock() {
-	p.closeOlIfNecessary()
-	p.closeUlIfNecessary()
-}
-
 func (p *TextileParser) parseBlock(l []byte) {
 	if len(l) == 0 {
-		p.closePrevBlock()
-		p.closeP()
+		if p.custom != nil && p.custom.Extended {
+			p.customBlank = true
+			return
+		}
+		if p.html != nil {
+			p.startNewLine()
+		} else {
+			p.closeBlock()
+		}
 		p.blockLineNo = 0
 		return
 	}
 	p.blockLineNo += 1
+	p.work = 0
 
+	if p.plain {
+		p.startNewLine()
+		p.addText(p.inl, l)
+		return
+	}
+	if p.code != nil || p.raw != nil {
+		p.literal.Write(newline)
+		p.literal.Write(l)
+		return
+	}
+	if p.custom != nil && p.addCustomLine(l) {
+		return
+	}
+	if p.comment {
+		return
+	}
 	if p.parseBlockStart(l) {
 		return
 	}
 
-	p.closePrevBlock()
+	p.closeLists()
+	p.table = nil
+	p.startNewLine()
 	if p.inHtmlCode() {
-		p.startNewLine()
-		p.serAsHtmlCode(l)
+		p.addText(p.inl, l)
 		return
 	}
-	p.startNewLine()
-	p.parseInline(l)
+	p.parseInline(p.inl, l)
+	p.endHtmlBlockIfClosed()
 }
 
 func dumpLines(lines [][]byte, out *bytes.Buffer) {
@@ -1221,9 +1738,66 @@ func dumpLines(lines [][]byte, out *bytes.Buffer) {
 	}
 }
 
+// dumpTree writes n and its descendants, one per line, indented by depth
+func dumpTree(n Node, out *bytes.Buffer) {
+	depth := 0
+	Inspect(n, func(n Node) bool {
+		if n == nil {
+			depth -= 1
+			return false
+		}
+		out.WriteString(strings.Repeat("  ", depth))
+		out.WriteString(strings.TrimPrefix(fmt.Sprintf("%T", n), "*textiler."))
+		switch n := n.(type) {
+		case *Text:
+			fmt.Fprintf(out, " %q", n.Text)
+		case *Code:
+			fmt.Fprintf(out, " %q", n.Text)
+		case *Acronym:
+			fmt.Fprintf(out, " %q %q", n.Text, n.Title)
+		case *CodeBlock:
+			fmt.Fprintf(out, " %q", n.Text)
+		case *RawHTML:
+			fmt.Fprintf(out, " %q", n.HTML)
+		case *Link:
+			fmt.Fprintf(out, " %q", n.URL)
+		case *Image:
+			fmt.Fprintf(out, " %q", n.Src)
+		}
+		out.Write(newline)
+		depth += 1
+		return true
+	})
+}
+
+// isSafeUrl returns true if url is relative or has one of the schemes
+// allowed in restricted mode
+func isSafeUrl(url string) bool {
+	i := strings.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(url[:i]) {
+	case "http", "https", "ftp", "mailto":
+		return true
+	}
+	return false
+}
+
+// lookupRef returns the url of the reference with the given name, defined
+// in the document or, if not, in the global references
+func (p *TextileParser) lookupRef(name string) (url string, ok bool) {
+	if urlRef, ok := p.refs[name]; ok {
+		return string(urlRef.url), true
+	}
+	url, ok = p.globalRefs[name]
+	return url, ok
+}
+
 func (p *TextileParser) parseRef(line []byte) bool {
 	if name, url := isUrlRef(line); name != nil {
 		p.refs[string(name)] = &UrlRef{name: name, url: url}
+		p.indexRef(string(name))
 		return true
 	}
 	return false
@@ -1234,7 +1808,8 @@ func (p *TextileParser) parseRef(line []byte) bool {
 func (p *TextileParser) firstPass(lines [][]byte) [][]byte {
 	res := make([][]byte, 0)
 	for _, l := range lines {
-		if !p.parseRef(l) {
+		// Convert can't see a whole long line, so it's never a reference
+		if p.isLongLine(l) || !p.parseRef(l) {
 			if len(l) > 0 {
 				res = append(res, l)
 			} else {
@@ -1251,36 +1826,248 @@ func (p *TextileParser) firstPass(lines [][]byte) [][]byte {
 	return res
 }
 
-func (p *TextileParser) toHtml(d []byte) []byte {
+func (p *TextileParser) startDocument() {
+	p.Reset()
+	p.doc = &Document{Refs: make(map[string]string)}
+}
+
+func (p *TextileParser) endDocument() {
+	p.closeBlock()
+	p.html = nil
+	for name, ref := range p.refs {
+		p.doc.Refs[name] = string(ref.url)
+	}
+}
+
+// startPlain switches to rendering the rest of the input as text
+func (p *TextileParser) startPlain(pos Pos) {
+	p.plain = true
+	p.report(pos, SeverityError, DiagInputTooLarge, "input is larger than %d bytes, the rest of it is text", p.limits.MaxInputSize)
+}
+
+// isLongLine returns true if l is longer than Limits.MaxLineSize
+func (p *TextileParser) isLongLine(l []byte) bool {
+	return p.limits.MaxLineSize > 0 && len(l) > p.limits.MaxLineSize
+}
+
+// anyLongLine returns true if any of lines is longer than
+// Limits.MaxLineSize
+func (p *TextileParser) anyLongLine(lines [][]byte) bool {
+	for _, l := range lines {
+		if p.isLongLine(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// startLongLine switches to rendering the rest of the input as text at a
+// line longer than Limits.MaxLineSize
+func (p *TextileParser) startLongLine() {
+	if p.plain {
+		return
+	}
+	p.plain = true
+	p.report(p.linePos(), SeverityError, DiagLineTooLong, "line is longer than %d bytes, it and the rest of the input are text", p.limits.MaxLineSize)
+}
+
+// addLongLinePart adds l, a part of a line longer than Limits.MaxLineSize,
+// as a paragraph of its own
+func (p *TextileParser) addLongLinePart(l []byte) {
+	p.closeBlock()
+	p.html = nil
+	p.para = &Paragraph{}
+	p.para.Pos = p.pos(l)
+	p.addBlock(p.para)
+	p.addText(&p.para.Container, l)
+	p.para = nil
+}
+
+// parseLongLine parses l, a line longer than Limits.MaxLineSize, as
+// paragraphs of text of at most that many bytes
+func (p *TextileParser) parseLongLine(l []byte) {
+	p.startLongLine()
+	for len(l) > p.limits.MaxLineSize {
+		n := cutRunes(l[:p.limits.MaxLineSize])
+		p.addLongLinePart(l[:n])
+		l = l[n:]
+	}
+	p.addLongLinePart(l)
+}
+
+// cutRunes returns the length of b without an incomplete utf-8 sequence at
+// its end, unless that would leave nothing
+func cutRunes(b []byte) int {
+	for i := len(b) - 1; i > 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// isIdle returns true if no block is being built, i.e. all blocks parsed so
+// far are complete
+func (p *TextileParser) isIdle() bool {
+	return p.inl == nil && p.html == nil && len(p.lists) == 0 &&
+		p.table == nil && p.code == nil && p.raw == nil && p.custom == nil
+}
+
+func (p *TextileParser) tracef(format string, args ...interface{}) {
+	if p.trace == nil || p.traceErr != nil {
+		return
+	}
+	_, p.traceErr = fmt.Fprintf(p.trace, format, args...)
+}
+
+// finishBlock post-processes a complete top-level block
+func (p *TextileParser) finishBlock(n Node) {
+	if p.imageRoot != nil || p.imageHook != nil {
+		p.processImages(n)
+	}
+	if p.linkResolver != nil {
+		p.resolveLinks(n)
+	} else if !p.parentChecksRefs {
+		p.checkRefs(n)
+	}
+	if p.glyphs {
+		applyGlyphs(n)
+	}
+	if p.slugs != nil {
+		p.slugs.add(n)
+	}
+	if p.toc {
+		p.headings = append(p.headings, headingsOf(n)...)
+	}
+	if p.titleFromHeading {
+		p.titleFrom(n)
+	}
+	if p.blockHook != nil {
+		p.blockHook(n)
+	}
+	if p.trace != nil {
+		var buf bytes.Buffer
+		dumpTree(n, &buf)
+		p.tracef("%s", buf.String())
+	}
+}
+
+func (p *TextileParser) parse(d []byte) *Document {
+	p.startDocument()
 	lines := splitIntoLines(d)
-	if p.dumpLines {
+	if p.limits.MaxInputSize > 0 && len(d) > p.limits.MaxInputSize {
+		p.setLine(d, 0, 1)
+		p.startPlain(p.linePos())
+	}
+	if p.trace != nil {
 		var buf bytes.Buffer
-		fmt.Print("----------\n")
 		dumpLines(lines, &buf)
-		fmt.Printf("%s", string(buf.Bytes()))
+		p.tracef("----------\n%s", buf.String())
+	}
+	// offsets of the lines, to find line numbers after firstPass
+	starts := make([]int, len(lines))
+	for i, l := range lines {
+		if starts[i] = offsetIn(d, l); starts[i] == -1 {
+			// an empty line at the end
+			starts[i] = len(d)
+		}
+	}
+	if p.frontMatter {
+		if end := frontMatterEnd(lines); end > 0 && !p.anyLongLine(lines[:end]) {
+			p.setMeta(lines[1:end])
+			lines = lines[end+1:]
+		}
 	}
 
 	lines = p.firstPass(lines)
 	for _, l := range lines {
+		if off := offsetIn(d, l); off != -1 {
+			p.setLine(l, off, sort.SearchInts(starts, off+1))
+		}
+		if p.isLongLine(l) {
+			p.parseLongLine(l)
+			continue
+		}
 		p.parseBlock(l)
 	}
-	p.closeOlIfNecessary()
-	p.closeUlIfNecessary()
-	p.closeP()
-	res := p.out.Bytes()
-	return bytes.TrimRight(res, "\n")
+	p.endDocument()
+	if p.slugs != nil {
+		for _, n := range p.doc.Nodes {
+			p.slugs.reserve(n)
+		}
+	}
+	for _, n := range p.doc.Nodes {
+		p.finishBlock(n)
+	}
+	if p.toc {
+		replaceTOC(p.doc, p.headings)
+	}
+	p.doc.Diagnostics = p.endDiagnostics()
+	return p.doc
 }
 
-func ToHtml(d []byte, dumpLines, dumpParagraphs bool) []byte {
-	p := NewParser(0)
-	p.dumpLines = dumpLines
-	p.dumpParagraphs = dumpParagraphs
+func (p *TextileParser) toHtml(d []byte) []byte {
+	return RenderHtml(p.parse(d), p.flags)
+}
+
+// Parse parses textile in d into a document tree
+func (p *TextileParser) Parse(d []byte) *Document {
+	return p.parse(d)
+}
+
+// ToHtml converts textile in d to html.
+func (p *TextileParser) ToHtml(d []byte) []byte {
 	return p.toHtml(d)
 }
 
+// ToMarkdown converts textile in d to markdown.
+func (p *TextileParser) ToMarkdown(d []byte) []byte {
+	return RenderMarkdown(p.parse(d))
+}
+
+// ToText converts textile in d to plain text.
+func (p *TextileParser) ToText(d []byte) []byte {
+	return RenderText(p.parse(d))
+}
+
+// ToTerminal converts textile in d to text with ANSI escapes.
+func (p *TextileParser) ToTerminal(d []byte) []byte {
+	return RenderTerminal(p.parse(d))
+}
+
+// ToLatex converts textile in d to a LaTeX fragment.
+func (p *TextileParser) ToLatex(d []byte) []byte {
+	return RenderLatex(p.parse(d))
+}
+
+// Parse parses textile in d into a document tree
+func Parse(d []byte) *Document {
+	return NewParser(0).Parse(d)
+}
+
+func toFlavor(d []byte, flavor Flavor, dumpLines, dumpParagraphs bool) []byte {
+	opts := &Options{Flavor: flavor}
+	if dumpLines || dumpParagraphs {
+		opts.Trace = os.Stdout
+	}
+	res, _, _ := ConvertBytes(d, opts)
+	return res
+}
+
+// ToHtml converts textile in d to html. If dumpLines or dumpParagraphs is
+// true, it prints the lines and the parsed blocks to stdout.
+//
+// Deprecated: use ConvertBytes, which takes Options.
+func ToHtml(d []byte, dumpLines, dumpParagraphs bool) []byte {
+	return toFlavor(d, FlavorHtml, dumpLines, dumpParagraphs)
+}
+
+// ToXhtml is like ToHtml, but converts to xhtml.
+//
+// Deprecated: use ConvertBytes, which takes Options.
 func ToXhtml(d []byte, dumpLines, dumpParagraphs bool) []byte {
-	p := NewParser(RENDERER_XHTML)
-	p.dumpLines = dumpLines
-	p.dumpParagraphs = dumpParagraphs
-	return p.toHtml(d)
+	return toFlavor(d, FlavorXhtml, dumpLines, dumpParagraphs)
 }
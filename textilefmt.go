@@ -0,0 +1,347 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TextileRenderer renders a document as textile in a canonical form: one
+// blank line between blocks, attributes in the order (class#id), alignment
+// and padding, {style}, [lang] and table cells as _\colspan/rowspan(attrs).
+// Blocks of html are rendered as their html. Columns of tables aren't
+// padded to align them, because spaces in cells end up in the html.
+type TextileRenderer struct {
+	// markers of the lists we're in, e.g. "*#" in a numbered list in a
+	// bulleted one
+	lists string
+	// the next item is the first of a top-level list
+	firstItem bool
+	// the next row is the first of a table
+	firstRow bool
+	inQuote  bool
+}
+
+func NewTextileRenderer() *TextileRenderer {
+	return &TextileRenderer{}
+}
+
+// short forms of styles, in the order they're written
+var shortStyles = []struct {
+	style, short string
+}{
+	{"text-align:justify;", "<>"},
+	{"text-align:left;", "<"},
+	{"text-align:right;", ">"},
+	{"text-align:center;", "="},
+}
+
+var shortImgStyles = []struct {
+	style, short string
+}{
+	{"float:left;", "<"},
+	{"float:right;", ">"},
+	{"display:block;margin:0auto;", "="},
+}
+
+// textileAttrs returns attrs as ($class#$id), alignment and padding,
+// {$style}[$lang]
+func textileAttrs(attrs Attributes, forImg bool) string {
+	s := ""
+	if attrs.Class != "" || attrs.ID != "" {
+		class := attrs.Class
+		if attrs.ID != "" {
+			class += "#" + attrs.ID
+		}
+		s += "(" + class + ")"
+	}
+	style := normalizeStyle(attrs.Style)
+	styles := shortStyles
+	if forImg {
+		styles = shortImgStyles
+	}
+	for _, short := range styles {
+		if strings.Contains(style, short.style) {
+			style = strings.Replace(style, short.style, "", 1)
+			s += short.short
+		}
+	}
+	if !forImg {
+		for _, side := range []string{"left", "right"} {
+			var n int
+			if _, err := fmt.Sscanf(style, "padding-"+side+":%dem;", &n); err == nil && n > 0 {
+				style = strings.Replace(style, fmt.Sprintf("padding-%s:%dem;", side, n), "", 1)
+				s += strings.Repeat(map[string]string{"left": "(", "right": ")"}[side], n)
+			}
+		}
+	}
+	if style != "" {
+		s += "{" + style + "}"
+	}
+	if attrs.Lang != "" {
+		s += "[" + attrs.Lang + "]"
+	}
+	return s
+}
+
+func (r *TextileRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.lists = ""
+	r.inQuote = false
+}
+
+func (r *TextileRenderer) DocumentFooter(out *bytes.Buffer) {
+}
+
+func (r *TextileRenderer) BlockSeparator(out *bytes.Buffer) {
+	out.WriteString("\n\n")
+}
+
+func (r *TextileRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if entering && !r.inQuote && !n.Attrs.IsEmpty() {
+		out.WriteString("p" + textileAttrs(n.Attrs, false) + ". ")
+	}
+}
+
+func (r *TextileRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		out.WriteString(fmt.Sprintf("h%d%s. ", n.Level, textileAttrs(n.Attrs, false)))
+	}
+}
+
+func (r *TextileRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	r.inQuote = entering
+	if !entering {
+		return
+	}
+	out.WriteString("bq" + textileAttrs(n.Attrs, false))
+	if n.Cite != "" {
+		out.WriteString(".:" + n.Cite + " ")
+	} else {
+		out.WriteString(". ")
+	}
+}
+
+func (r *TextileRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if !entering {
+		r.lists = r.lists[:len(r.lists)-1]
+		return
+	}
+	if r.lists == "" {
+		r.firstItem = true
+	}
+	if n.Ordered {
+		r.lists += "#"
+	} else {
+		r.lists += "*"
+	}
+}
+
+func (r *TextileRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	if !entering {
+		return
+	}
+	if !r.firstItem {
+		out.WriteString("\n")
+	}
+	r.firstItem = false
+	out.WriteString(r.lists)
+	if len(n.Nodes) > 0 {
+		out.WriteString(" ")
+	}
+}
+
+func (r *TextileRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	signature := "bc"
+	if n.Preformatted {
+		signature = "pre"
+	}
+	out.WriteString(signature + textileAttrs(n.Attrs, false) + ". " + n.Text)
+}
+
+func (r *TextileRenderer) HTMLBlock(out *bytes.Buffer, n *HTMLBlock, entering bool) {
+}
+
+func (r *TextileRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if !entering {
+		return
+	}
+	r.firstRow = true
+	if !n.Attrs.IsEmpty() {
+		out.WriteString("table" + textileAttrs(n.Attrs, false) + ".")
+		r.firstRow = false
+	}
+}
+
+func (r *TextileRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	if !entering {
+		out.WriteString("|")
+		return
+	}
+	if !r.firstRow {
+		out.WriteString("\n")
+	}
+	r.firstRow = false
+	if !n.Attrs.IsEmpty() {
+		out.WriteString(textileAttrs(n.Attrs, false) + ". ")
+	}
+}
+
+func (r *TextileRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	if !entering {
+		return
+	}
+	out.WriteString("|")
+	spec := ""
+	if n.Header {
+		spec += "_"
+	}
+	if n.Colspan > 0 {
+		spec += fmt.Sprintf("\\%d", n.Colspan)
+	}
+	if n.Rowspan > 0 {
+		spec += fmt.Sprintf("/%d", n.Rowspan)
+	}
+	if spec += textileAttrs(n.Attrs, false); spec != "" {
+		out.WriteString(spec + ". ")
+	}
+}
+
+func (r *TextileRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	if entering {
+		out.WriteString("fn" + n.ID + textileAttrs(n.Attrs, false) + ". ")
+	}
+}
+
+func (r *TextileRenderer) Text(out *bytes.Buffer, n *Text) {
+	out.WriteString(n.Text)
+}
+
+func (r *TextileRenderer) LineBreak(out *bytes.Buffer, n *LineBreak) {
+	out.WriteString("\n")
+}
+
+func (r *TextileRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	out.WriteString(phraseMarkers[n.Tag])
+	if entering {
+		out.WriteString(textileAttrs(n.Attrs, false))
+	}
+}
+
+func (r *TextileRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+	out.WriteString("%")
+	if entering {
+		out.WriteString(textileAttrs(n.Attrs, false))
+	}
+}
+
+func (r *TextileRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	out.WriteString("@" + n.Text + "@")
+}
+
+func (r *TextileRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	out.WriteString(n.Text + "(" + n.Title + ")")
+}
+
+func (r *TextileRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if entering {
+		out.WriteString(`"`)
+		return
+	}
+	target := n.URL
+	if n.Ref != "" {
+		target = n.Ref
+	}
+	out.WriteString(`":` + target)
+}
+
+func (r *TextileRenderer) Image(out *bytes.Buffer, n *Image) {
+	out.WriteString("!" + textileAttrs(n.Attrs, true) + n.Src)
+	if n.Alt != "" {
+		out.WriteString("(" + n.Alt + ")")
+	}
+	out.WriteString("!")
+	if n.URL != "" {
+		out.WriteString(":" + n.URL)
+	}
+}
+
+func (r *TextileRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	out.WriteString(n.HTML)
+}
+
+func (r *TextileRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	out.WriteString("[" + n.ID + "]")
+}
+
+// Reformat parses textile in d and writes it back in the canonical form of
+// TextileRenderer, with definitions of references at the end, sorted by
+// name. Blocks whose canonical form would be rendered differently are kept
+// as they are written in d, so the html of the result is the same as of d.
+// Reformatting the result doesn't change it.
+func Reformat(d []byte) []byte {
+	res, ok := reformat(d)
+	if !ok {
+		return d
+	}
+	return res
+}
+
+// reformat returns d in canonical form and true, or false if the html of
+// the result differs from the one of d
+func reformat(d []byte) ([]byte, bool) {
+	doc := Parse(d)
+	exp, _, _ := ConvertBytes(d, nil)
+	opts := &Options{Refs: doc.Refs}
+	var blocks []string
+	for i, n := range doc.Nodes {
+		block := &Document{Container: Container{Nodes: []Node{n}}, Refs: doc.Refs}
+		html := Render(block, NewHtmlRenderer())
+		s := string(Render(block, NewTextileRenderer()))
+		candidates := []string{s}
+		if _, ok := n.(*Paragraph); ok {
+			// for text that looks like a signature
+			candidates = append(candidates, "p. "+s)
+		}
+		end := len(d)
+		if i+1 < len(doc.Nodes) {
+			end = doc.Nodes[i+1].Position().Offset
+		}
+		candidates = append(candidates, sourceOf(d[n.Position().Offset:end]))
+		for _, s := range candidates {
+			got, _, _ := ConvertBytes([]byte(s), opts)
+			if bytes.Equal(got, html) || s == candidates[len(candidates)-1] {
+				blocks = append(blocks, s)
+				break
+			}
+		}
+	}
+	var names []string
+	for name := range doc.Refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var refs []string
+	for _, name := range names {
+		refs = append(refs, "["+name+"]"+doc.Refs[name])
+	}
+	if len(refs) > 0 {
+		blocks = append(blocks, strings.Join(refs, "\n"))
+	}
+	res := []byte(strings.Join(blocks, "\n\n") + "\n")
+	// e.g. blocks that depend on the ones before them
+	got, _, _ := ConvertBytes(res, nil)
+	return res, bytes.Equal(got, exp)
+}
+
+// sourceOf returns the source of a block without definitions of references
+// and the blank lines after it
+func sourceOf(d []byte) string {
+	var lines []string
+	for _, l := range strings.Split(string(d), "\n") {
+		if name, _ := isUrlRef([]byte(strings.TrimRight(l, "\r"))); name == nil {
+			lines = append(lines, l)
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), " \t\r\n")
+}
@@ -0,0 +1,55 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestReformatCorpus(t *testing.T) {
+	corpus := append(append([]string{}, HtmlTests...), XhtmlTests...)
+	for i := 0; i < len(corpus); i += 2 {
+		s := corpus[i]
+		res, ok := reformat([]byte(s))
+		if !ok {
+			t.Fatalf("\nSrc:%#v\n\nReformatted:%#v\n\nhtml differs, the source is kept", s, res)
+		}
+		got := string(res)
+		if exp, html := convertBytes(t, s, nil), convertBytes(t, got, nil); html != exp {
+			t.Fatalf("\nSrc:%#v\n\nReformatted:%#v\n\nExp:%#v\n\nGot:%#v\n", s, got, exp, html)
+		}
+		if again, ok := reformat([]byte(got)); !ok || string(again) != got {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", got, got, string(again))
+		}
+	}
+}
+
+func TestReformat(t *testing.T) {
+	tests := []string{
+		"p[fr]{color:red}(cls). text\n\n\n\nh2>(). Title\np. quux",
+		"p(cls){color:red;}[fr]. text\n\nh2>(). Title\n\nquux\n",
+
+		"[b]http://b.com\nA \"link\":b and \"another\":a.\n[a]http://a.com",
+		"A \"link\":b and \"another\":a.\n\n[a]http://a.com\n[b]http://b.com\n",
+
+		"|_{color:red}. a|/2\\2. b|\n(row). |c|",
+		"|_{color:red;}. a|\\2/2. b|\n(row). |c|\n",
+
+		"* one\n** two\n# x",
+		"* one\n** two\n\n# x\n",
+
+		"fn1{color:red}. note\n\nbq.:http://c.com *quote*\n\n!>img.png(alt)!:http://x.com",
+		"fn1{color:red;}. note\n\nbq.:http://c.com *quote*\n\n!>img.png(alt)!:http://x.com\n",
+
+		// textile that looks like a signature
+		"p. h2. not a heading",
+		"p. h2. not a heading\n",
+
+		// spaces in cells are kept in the html, so columns aren't aligned
+		"|a|bbbbbb|\n|ccc|d|",
+		"|a|bbbbbb|\n|ccc|d|\n",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		if got := string(Reformat([]byte(tests[i]))); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package textiler
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tocPlaceholder is the text of a paragraph that is replaced by the table
+// of contents if Options.TOC is set
+const tocPlaceholder = "{toc}"
+
+// slugger generates unique ids for headings
+type slugger struct {
+	prefix string
+	// ids of headings so far
+	seen map[string]bool
+}
+
+func newSlugger(prefix string) *slugger {
+	return &slugger{prefix: prefix, seen: make(map[string]bool)}
+}
+
+func (s *slugger) reset() {
+	for id := range s.seen {
+		delete(s.seen, id)
+	}
+}
+
+// slugify returns s in lower case, with letters and digits of any script
+// kept, spaces, dashes and underscores turned into single dashes and other
+// characters dropped
+func slugify(s string) string {
+	var buf strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.Is(unicode.Mn, c):
+			if dash && buf.Len() > 0 {
+				buf.WriteByte('-')
+			}
+			dash = false
+			buf.WriteRune(c)
+		case unicode.IsSpace(c) || c == '-' || c == '_' || unicode.Is(unicode.Pd, c):
+			dash = true
+		}
+	}
+	if buf.Len() == 0 {
+		return "section"
+	}
+	return buf.String()
+}
+
+// reserve marks the explicit ids of headings in n as taken, so that ids
+// generated for earlier headings don't repeat them
+func (s *slugger) reserve(n Node) {
+	for _, h := range headingsOf(n) {
+		if h.Attrs.ID != "" {
+			s.seen[h.Attrs.ID] = true
+		}
+	}
+}
+
+// add sets ids of headings in n that don't have one to slugs of their text,
+// which are made unique by appending -1, -2 etc.
+func (s *slugger) add(n Node) {
+	Inspect(n, func(n Node) bool {
+		h, ok := n.(*Heading)
+		if !ok {
+			return n != nil
+		}
+		if h.Attrs.ID != "" {
+			s.seen[h.Attrs.ID] = true
+			return false
+		}
+		base := s.prefix + slugify(textOf(h))
+		id := base
+		for i := 1; s.seen[id]; i++ {
+			id = base + "-" + strconv.Itoa(i)
+		}
+		s.seen[id] = true
+		h.Attrs.ID = id
+		return false
+	})
+}
+
+// AddHeadingIDs sets ids of headings in doc that don't have one, like
+// Options.HeadingIDs does: the id is the text of the heading in lower case,
+// with spaces replaced by dashes and punctuation dropped, and a number
+// appended if another heading has the same id.
+func AddHeadingIDs(doc *Document) {
+	s := newSlugger("")
+	for _, n := range doc.Nodes {
+		s.reserve(n)
+	}
+	for _, n := range doc.Nodes {
+		s.add(n)
+	}
+}
+
+// OutlineItem is a heading in the outline of a document
+type OutlineItem struct {
+	Level int
+	ID    string
+	// Text is the text of the heading without markup
+	Text string
+	// Children are the headings of a higher level that follow this one,
+	// up to the next one of the same or a lower level
+	Children []*OutlineItem
+}
+
+// headingsOf returns the headings in n
+func headingsOf(n Node) []*Heading {
+	var headings []*Heading
+	Inspect(n, func(n Node) bool {
+		if h, ok := n.(*Heading); ok {
+			headings = append(headings, h)
+			return false
+		}
+		return n != nil
+	})
+	return headings
+}
+
+// outline returns headings as a tree, nested by their levels
+func outline(headings []*Heading) []*OutlineItem {
+	var items []*OutlineItem
+	// the last item of each level of nesting
+	var stack []*OutlineItem
+	for _, h := range headings {
+		item := &OutlineItem{Level: h.Level, ID: h.Attrs.ID, Text: textOf(h)}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= h.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			items = append(items, item)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, item)
+		}
+		stack = append(stack, item)
+	}
+	return items
+}
+
+// Outline returns the headings of doc as a tree. The ids of headings are
+// empty unless they're set, e.g. with Options.HeadingIDs or AddHeadingIDs.
+func Outline(doc *Document) []*OutlineItem {
+	return outline(headingsOf(&doc.Container))
+}
+
+// tocList returns items as a bulleted list of links to the headings, or
+// of their text if they don't have an id
+func tocList(items []*OutlineItem) *List {
+	list := &List{}
+	for _, item := range items {
+		li := &ListItem{}
+		text := &Text{Text: item.Text}
+		if item.ID != "" {
+			link := &Link{URL: "#" + item.ID}
+			link.AppendChild(text)
+			li.AppendChild(link)
+		} else {
+			li.AppendChild(text)
+		}
+		if len(item.Children) > 0 {
+			li.AppendChild(tocList(item.Children))
+		}
+		list.AppendChild(li)
+	}
+	return list
+}
+
+// TOC returns the table of contents of doc, a nested list of class "toc"
+// with links to its headings, as a document that can be rendered
+// separately, e.g.
+//
+//	toc := textiler.Render(textiler.TOC(doc), textiler.NewHtmlRenderer())
+//
+// The document is empty if doc has no headings.
+func TOC(doc *Document) *Document {
+	toc := &Document{Refs: map[string]string{}}
+	if items := Outline(doc); len(items) > 0 {
+		list := tocList(items)
+		list.Attrs.Class = "toc"
+		toc.AppendChild(list)
+	}
+	return toc
+}
+
+// isTOCPlaceholder returns true if n is a {toc} paragraph
+func isTOCPlaceholder(n Node) bool {
+	p, ok := n.(*Paragraph)
+	if !ok || !p.Attrs.IsEmpty() || len(p.Nodes) != 1 {
+		return false
+	}
+	t, ok := p.Nodes[0].(*Text)
+	return ok && strings.TrimSpace(t.Text) == tocPlaceholder
+}
+
+// tocFor returns the table of contents that replaces the {toc} placeholder
+// n, nil if there are no headings
+func tocFor(n Node, headings []*Heading) Node {
+	items := outline(headings)
+	if len(items) == 0 {
+		return nil
+	}
+	list := tocList(items)
+	list.Attrs.Class = "toc"
+	list.Pos = n.Position()
+	return list
+}
+
+// replaceTOC replaces {toc} paragraphs in doc with the table of contents
+// of headings and removes them if there are none
+func replaceTOC(doc *Document, headings []*Heading) {
+	nodes := doc.Nodes[:0]
+	for _, n := range doc.Nodes {
+		if isTOCPlaceholder(n) {
+			if n = tocFor(n, headings); n == nil {
+				continue
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	for i := len(nodes); i < len(doc.Nodes); i++ {
+		doc.Nodes[i] = nil
+	}
+	doc.Nodes = nodes
+}
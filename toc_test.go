@@ -0,0 +1,91 @@
+package textiler
+
+import (
+	"testing"
+)
+
+func TestHeadingIDs(t *testing.T) {
+	tests := []string{
+		"h1. Hello, World!\n\nh2. Hello world\n\nh2(#own). Mine\n\nh2. Own",
+		"\t<h1 id=\"hello-world\">Hello, World!</h1>\n\n\t<h2 id=\"hello-world-1\">Hello world</h2>\n\n" +
+			"\t<h2 id=\"own\">Mine</h2>\n\n\t<h2 id=\"own-1\">Own</h2>",
+
+		"h2. Привет, мир\n\nh3. Über -- café\n\nh2. ***\n\nh2. x & y",
+		"\t<h2 id=\"привет-мир\">Привет, мир</h2>\n\n\t<h3 id=\"über-café\">Über -- café</h3>\n\n" +
+			"\t<h2 id=\"section\">***</h2>\n\n\t<h2 id=\"x-y\">x &amp; y</h2>",
+
+		// explicit ids of later headings are taken too
+		"h1. Foo\n\nh1. Foo\n\nh2(#foo-1). x",
+		"\t<h1 id=\"foo\">Foo</h1>\n\n\t<h1 id=\"foo-2\">Foo</h1>\n\n\t<h2 id=\"foo-1\">x</h2>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		if got := convertBytes(t, tests[i], &Options{HeadingIDs: true}); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+	got := convertBytes(t, "h1. A\n\nh1. A", &Options{HeadingIDs: true, IDPrefix: "p-"})
+	if exp := "\t<h1 id=\"p-a\">A</h1>\n\n\t<h1 id=\"p-a-1\">A</h1>"; got != exp {
+		t.Fatalf("\nExp:%#v\n\nGot:%#v\n", exp, got)
+	}
+}
+
+func TestOutline(t *testing.T) {
+	doc := Parse([]byte("h2. A\n\nh3. B\n\nh4. C\n\nh3. D\n\nh1. E"))
+	AddHeadingIDs(doc)
+	items := Outline(doc)
+	if len(items) != 2 || items[0].ID != "a" || items[1].Text != "E" || items[1].Level != 1 {
+		t.Fatalf("%+v", items)
+	}
+	if c := items[0].Children; len(c) != 2 || c[0].ID != "b" || c[1].ID != "d" ||
+		len(c[0].Children) != 1 || c[0].Children[0].Text != "C" {
+		t.Fatalf("%+v", c)
+	}
+
+	doc2 := Parse([]byte("h1. Foo\n\nh1. Foo\n\nh2(#foo-1). x"))
+	AddHeadingIDs(doc2)
+	if h := headingsOf(&doc2.Container); h[1].Attrs.ID != "foo-2" {
+		t.Fatalf("got id %q, expected foo-2", h[1].Attrs.ID)
+	}
+
+	got := string(Render(TOC(doc), NewHtmlRenderer()))
+	exp := "\t<ul class=\"toc\">\n\t\t<li><a href=\"#a\">A</a>\n\t<ul>\n\t\t<li><a href=\"#b\">B</a>\n\t<ul>\n" +
+		"\t\t<li><a href=\"#c\">C</a></li>\n\t</ul></li>\n\t\t<li><a href=\"#d\">D</a></li>\n\t</ul></li>\n" +
+		"\t\t<li><a href=\"#e\">E</a></li>\n\t</ul>"
+	if got != exp {
+		t.Fatalf("\nExp:%#v\n\nGot:%#v\n", exp, got)
+	}
+	if toc := TOC(Parse([]byte("No headings."))); len(toc.Nodes) != 0 {
+		t.Fatalf("%d nodes", len(toc.Nodes))
+	}
+}
+
+func TestTOCPlaceholder(t *testing.T) {
+	tests := []string{
+		"{toc}\n\nh1. A & B\n\nh2. C\n\nh1. D",
+		"\t<ul class=\"toc\">\n\t\t<li><a href=\"#a-b\">A &amp; B</a>\n\t<ul>\n\t\t<li><a href=\"#c\">C</a></li>\n" +
+			"\t</ul></li>\n\t\t<li><a href=\"#d\">D</a></li>\n\t</ul>\n\n" +
+			"\t<h1 id=\"a-b\">A &amp; B</h1>\n\n\t<h2 id=\"c\">C</h2>\n\n\t<h1 id=\"d\">D</h1>",
+
+		"Text\n\n{toc}",
+		"\t<p>Text</p>",
+
+		"p(x). {toc}\n\nh1. A",
+		"\t<p class=\"x\">{toc}</p>\n\n\t<h1 id=\"a\">A</h1>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		opts := &Options{TOC: true}
+		if got := convertBytes(t, tests[i], opts); got != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+		got, err := convertString(tests[i], opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tests[i+1] {
+			t.Fatalf("Convert\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", tests[i], tests[i+1], got)
+		}
+	}
+	if got := convertBytes(t, "{toc}", nil); got != "\t<p>{toc}</p>" {
+		t.Fatalf("%#v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package textiler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// LinkResolver returns the url of a link with the given text and target:
+// the url or reference name after "text": or the page of a [[Page]] wiki
+// link. ok is false if the target doesn't exist; such links are rendered
+// as missing (red) links to url. If url is empty, the target is used as
+// the url.
+//
+// It's called for links that aren't resolved by references.
+type LinkResolver func(text, target string) (url string, ok bool)
+
+// [[$page]] or [[$page|$text]]
+func parseWikiLink(l []byte) (rest, page, text []byte) {
+	if !bytes.HasPrefix(l, []byte("[[")) {
+		return nil, nil, nil
+	}
+	end := bytes.Index(l[2:], []byte("]]"))
+	if end == -1 {
+		return nil, nil, nil
+	}
+	inside := l[2 : 2+end]
+	rest = l[2+end+2:]
+	page, text = inside, inside
+	if i := bytes.IndexByte(inside, '|'); i != -1 {
+		page, text = inside[:i], inside[i+1:]
+	}
+	page = bytes.TrimSpace(page)
+	if len(page) == 0 || len(bytes.TrimSpace(text)) == 0 {
+		return nil, nil, nil
+	}
+	return rest, page, text
+}
+
+func (p *TextileParser) addWikiLink(c *Container, before, page, text []byte) {
+	p.addText(c, before)
+	if p.restricted && !isSafeUrl(string(page)) {
+		p.parseInline(c, text)
+		return
+	}
+	n := &Link{URL: string(page), Wiki: true}
+	n.Pos = p.posAfter(before)
+	c.AppendChild(n)
+	p.parseInline(&n.Container, text)
+}
+
+// textOf returns the text of n and its descendants, without markup
+func textOf(n Node) string {
+	var buf strings.Builder
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *Text:
+			buf.WriteString(n.Text)
+		case *Code:
+			buf.WriteString(n.Text)
+		case *Acronym:
+			buf.WriteString(n.Text)
+		}
+		return true
+	})
+	return buf.String()
+}
+
+// resolveLinks resolves links in n that references didn't resolve with the
+// link resolver
+func (p *TextileParser) resolveLinks(n Node) {
+	Inspect(n, func(n Node) bool {
+		l, ok := n.(*Link)
+		if !ok || l.Ref != "" || l.resolved {
+			return true
+		}
+		l.resolved = true
+		url, ok := p.linkResolver(textOf(l), l.URL)
+		if !ok {
+			p.report(l.Pos, SeverityWarning, DiagMissingLink, "link target %q doesn't exist", l.URL)
+		}
+		if url != "" {
+			l.URL = url
+		}
+		l.Missing = !ok
+		return true
+	})
+}
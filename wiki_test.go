@@ -0,0 +1,55 @@
+package textiler
+
+import (
+	"strings"
+	"testing"
+)
+
+var wikiPages = map[string]bool{"Home": true, "Getting Started": true}
+
+func wikiResolver(text, target string) (string, bool) {
+	if strings.Contains(target, "://") {
+		return target, true
+	}
+	return "/wiki/" + strings.Replace(target, " ", "_", -1), wikiPages[target]
+}
+
+func TestWikiLinks(t *testing.T) {
+	opts := &Options{WikiLinks: true, LinkResolver: wikiResolver}
+	tests := []string{
+		"See [[Home]] and [[Getting Started|the _guide_]].",
+		"\t<p>See <a href=\"/wiki/Home\">Home</a> and <a href=\"/wiki/Getting_Started\">the <em>guide</em></a>.</p>",
+
+		"[[Nowhere]] and \"a\":Home and \"b\":http://b.com",
+		"\t<p><a href=\"/wiki/Nowhere\" class=\"missing\">Nowhere</a> and <a href=\"/wiki/Home\">a</a> and <a href=\"http://b.com\">b</a></p>",
+
+		// references take precedence over the resolver
+		"\"a\":Gone\n\n[Gone]http://gone.com",
+		"\t<p><a href=\"http://gone.com\">a</a></p>",
+
+		"[[|x]] and [[ ]] aren't links",
+		"\t<p>[[|x]] and [[ ]] aren&#8217;t links</p>",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		s := tests[i]
+		got, _, err := ConvertBytes([]byte(s), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", s, tests[i+1], string(got))
+		}
+		if streamed, _ := convertString(s, opts); streamed != string(got) {
+			t.Fatalf("\nSrc:%#v\n\nConvert:%#v\n\nConvertBytes:%#v\n", s, streamed, string(got))
+		}
+	}
+
+	_, diags, _ := ConvertBytes([]byte("[[Home]] [[Nowhere]]"), opts)
+	if got := diagCodes(diags); len(got) != 1 || got[0] != "1:10 missing-link" {
+		t.Fatalf("unexpected diagnostics %v", got)
+	}
+	// without WikiLinks [[ ]] is text
+	if got := textileToHtml("[[Home]]"); got != "\t<p>[[Home]]</p>" {
+		t.Fatalf("unexpected %#v", got)
+	}
+}
@@ -0,0 +1,424 @@
+package textiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// xhtmlElement is an element opened by raw html
+type xhtmlElement struct {
+	tag string
+	// XhtmlRenderer.nodes when it was opened
+	nodes int
+}
+
+// isXmlChar returns true if c may appear in xml
+func isXmlChar(c rune) bool {
+	return c == '\t' || c == '\n' || c == '\r' ||
+		c >= 0x20 && c <= 0xd7ff || c >= 0xe000 && c <= 0xfffd || c >= 0x10000 && c <= 0x10ffff
+}
+
+// isXmlName returns true if s is a name of an element or attribute that is
+// valid in xml, only ascii names are accepted. Colons separate prefixes of
+// namespaces, so they may not start or end a name.
+func isXmlName(s string) bool {
+	if s == "" || s[len(s)-1] == ':' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (isDigit(c) || c == '-' || c == '.' || c == ':'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// xmlChars returns s without characters xml doesn't allow
+func xmlChars(s string) string {
+	return strings.Map(func(c rune) rune {
+		if !isXmlChar(c) {
+			return -1
+		}
+		return c
+	}, s)
+}
+
+// dropNonXmlChars removes characters xml doesn't allow from what was
+// written to out since start, e.g. control characters in text
+func dropNonXmlChars(out *bytes.Buffer, start int) {
+	b := out.Bytes()[start:]
+	for i := 0; i < len(b); i++ {
+		if c := b[i]; c >= utf8.RuneSelf || c < ' ' && c != '\t' && c != '\n' && c != '\r' {
+			s := xmlChars(string(b))
+			out.Truncate(start)
+			out.WriteString(s)
+			return
+		}
+	}
+}
+
+// xmlText returns text of raw html as xml: named character references
+// other than the ones xml predefines are replaced by numeric ones, '&' and
+// '<' that don't start a reference or tag are escaped and so is '>', which
+// may end a "]]>" that started in another token
+func xmlText(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case c == '&':
+			n, text := parseCharRef(s[i:])
+			switch ref := s[i : i+n]; {
+			case n == 0:
+				buf.WriteString("&amp;")
+				size = 1
+			case ref == "&amp;" || ref == "&lt;" || ref == "&gt;" || ref == "&quot;" || ref == "&apos;":
+				buf.WriteString(ref)
+				size = n
+			default:
+				for _, c := range text {
+					if !isXmlChar(c) {
+						c = utf8.RuneError
+					}
+					fmt.Fprintf(&buf, "&#%d;", c)
+				}
+				size = n
+			}
+		case c == '<':
+			buf.WriteString("&lt;")
+		case c == '>':
+			buf.WriteString("&gt;")
+		case c == utf8.RuneError && size == 1, !isXmlChar(c):
+			// invalid utf-8 and characters xml doesn't allow
+		default:
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+// xmlEscape escapes s for text or a value of an attribute in xml
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '&':
+			buf.WriteString("&amp;")
+		case c == '<':
+			buf.WriteString("&lt;")
+		case c == '>':
+			buf.WriteString("&gt;")
+		case c == '"':
+			buf.WriteString("&quot;")
+		case c == utf8.RuneError, !isXmlChar(c):
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// xmlComment returns the text of a comment without "--", which xml doesn't
+// allow in comments
+func xmlComment(s string) string {
+	for strings.Contains(s, "--") {
+		s = strings.Replace(s, "--", "- -", -1)
+	}
+	if strings.HasSuffix(s, "-") {
+		s += " "
+	}
+	return xmlChars(s)
+}
+
+func (r *XhtmlRenderer) DocumentHeader(out *bytes.Buffer) {
+	r.HtmlRenderer.DocumentHeader(out)
+	r.open = r.open[:0]
+	r.nodes = 0
+}
+
+// DocumentFooter closes elements that raw html left open
+func (r *XhtmlRenderer) DocumentFooter(out *bytes.Buffer) {
+	r.close(out)
+	r.HtmlRenderer.DocumentFooter(out)
+}
+
+// enter is called when a node with children is entered and leave when it's
+// left. leave closes elements opened by raw html inside of the node, so that
+// they don't overlap with the element of the node.
+func (r *XhtmlRenderer) enter() {
+	r.nodes += 1
+}
+
+func (r *XhtmlRenderer) leave(out *bytes.Buffer) {
+	r.close(out)
+	r.nodes -= 1
+}
+
+// close closes elements opened by raw html inside of the current node
+func (r *XhtmlRenderer) close(out *bytes.Buffer) {
+	for len(r.open) > 0 && r.open[len(r.open)-1].nodes == r.nodes {
+		out.WriteString("</" + r.open[len(r.open)-1].tag + ">")
+		r.open = r.open[:len(r.open)-1]
+	}
+}
+
+// endTag closes the element tag and the ones opened after it, if it was
+// opened inside of the current node. Otherwise the end tag is dropped.
+func (r *XhtmlRenderer) endTag(out *bytes.Buffer, tag string) {
+	for i := len(r.open) - 1; i >= 0 && r.open[i].nodes == r.nodes; i-- {
+		if r.open[i].tag != tag {
+			continue
+		}
+		for len(r.open) > i {
+			out.WriteString("</" + r.open[len(r.open)-1].tag + ">")
+			r.open = r.open[:len(r.open)-1]
+		}
+		return
+	}
+}
+
+// startTag writes t with quoted attributes, each once, and closes it if
+// it's empty
+func (r *XhtmlRenderer) startTag(out *bytes.Buffer, t htmlToken) {
+	out.WriteString("<" + t.tag)
+	seen := make(map[string]bool)
+	for _, a := range t.attrs {
+		if !isXmlName(a.name) || seen[a.name] {
+			continue
+		}
+		seen[a.name] = true
+		value := a.value
+		if a.bare {
+			// <input disabled> is <input disabled="disabled"> in xhtml
+			value = a.name
+		}
+		out.WriteString(" " + a.name + `="` + xmlEscape(value) + `"`)
+	}
+	if voidTags[t.tag] || t.selfClosing {
+		out.WriteString(" />")
+		return
+	}
+	out.WriteString(">")
+	r.open = append(r.open, xhtmlElement{tag: t.tag, nodes: r.nodes})
+}
+
+// RawHTML re-serializes html as xml: attributes are quoted, empty elements
+// are closed, named character references are replaced by numeric ones and
+// end tags that don't match an open element are dropped. Elements that
+// aren't closed are closed at the end of the node they're in, e.g. a
+// paragraph, or of the document.
+func (r *XhtmlRenderer) RawHTML(out *bytes.Buffer, n *RawHTML) {
+	z := &htmlTokenizer{s: n.HTML}
+	for {
+		start := z.pos
+		rawText := z.rawTag != ""
+		t, ok := z.next()
+		if !ok {
+			return
+		}
+		raw := n.HTML[start:z.pos]
+		switch t.typ {
+		case htmlText:
+			if rawText {
+				// content of <script> and <style> is not html
+				out.WriteString(xmlEscape(raw))
+			} else {
+				out.WriteString(xmlText(raw))
+			}
+		case htmlStartTag:
+			if !isXmlName(t.tag) {
+				out.WriteString(xmlText(raw))
+				continue
+			}
+			r.startTag(out, t)
+		case htmlEndTag:
+			r.endTag(out, t.tag)
+		case htmlComment:
+			// <!doctype> and other bogus comments are dropped
+			if strings.HasPrefix(raw, "<!--") {
+				out.WriteString("<!--" + xmlComment(t.text) + "-->")
+			}
+		}
+	}
+}
+
+func (r *XhtmlRenderer) Paragraph(out *bytes.Buffer, n *Paragraph, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Paragraph(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Paragraph(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Heading(out *bytes.Buffer, n *Heading, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Heading(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Heading(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) BlockQuote(out *bytes.Buffer, n *BlockQuote, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.BlockQuote(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.BlockQuote(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) List(out *bytes.Buffer, n *List, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.List(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.List(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) ListItem(out *bytes.Buffer, n *ListItem, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.ListItem(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.ListItem(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Table(out *bytes.Buffer, n *Table, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Table(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Table(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) TableRow(out *bytes.Buffer, n *TableRow, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.TableRow(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.TableRow(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) TableCell(out *bytes.Buffer, n *TableCell, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.TableCell(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.TableCell(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Footnote(out *bytes.Buffer, n *Footnote, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Footnote(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Footnote(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Phrase(out *bytes.Buffer, n *Phrase, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Phrase(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Phrase(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Span(out *bytes.Buffer, n *Span, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Span(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Span(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) Link(out *bytes.Buffer, n *Link, entering bool) {
+	if entering {
+		start := out.Len()
+		r.HtmlRenderer.Link(out, n, entering)
+		dropNonXmlChars(out, start)
+		r.enter()
+	} else {
+		r.leave(out)
+		r.HtmlRenderer.Link(out, n, entering)
+	}
+}
+
+func (r *XhtmlRenderer) CodeBlock(out *bytes.Buffer, n *CodeBlock) {
+	start := out.Len()
+	r.HtmlRenderer.CodeBlock(out, n)
+	dropNonXmlChars(out, start)
+}
+
+func (r *XhtmlRenderer) Text(out *bytes.Buffer, n *Text) {
+	start := out.Len()
+	r.HtmlRenderer.Text(out, n)
+	dropNonXmlChars(out, start)
+}
+
+func (r *XhtmlRenderer) CodeSpan(out *bytes.Buffer, n *Code) {
+	start := out.Len()
+	r.HtmlRenderer.CodeSpan(out, n)
+	dropNonXmlChars(out, start)
+}
+
+func (r *XhtmlRenderer) Acronym(out *bytes.Buffer, n *Acronym) {
+	start := out.Len()
+	r.HtmlRenderer.Acronym(out, n)
+	dropNonXmlChars(out, start)
+}
+
+func (r *XhtmlRenderer) FootnoteRef(out *bytes.Buffer, n *FootnoteRef) {
+	start := out.Len()
+	r.HtmlRenderer.FootnoteRef(out, n)
+	dropNonXmlChars(out, start)
+}
@@ -0,0 +1,108 @@
+package textiler
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// checkXml fails if s isn't well-formed xml, with entities that strict
+// encoding/xml doesn't know
+func checkXml(t *testing.T, src, s string) {
+	d := xml.NewDecoder(strings.NewReader("<root>" + s + "</root>"))
+	d.Strict = true
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("\nSrc:%#v\n\nXhtml:%#v\n\n%s", src, s, err)
+		}
+	}
+}
+
+var xhtmlTests = []string{
+	"notextile. <p>a<br>b &nbsp; &copy; &bogus; & c</p>",
+	"<p>a<br />b &#160; &#169; &amp;bogus; &amp; c</p>",
+
+	"<div class=a id='b' data-x=\"1\" class=c>\n\ntext\n\n</div>",
+	"<div class=\"a\" id=\"b\" data-x=\"1\">\n\n\t<p>text</p>\n\n</div>",
+
+	"notextile. <input type=checkbox checked><br></br><img src=x.png alt=''>",
+	"<input type=\"checkbox\" checked=\"checked\" /><br /><img src=\"x.png\" alt=\"\" />",
+
+	"Some <b>unclosed\n\nand</i> stray",
+	"\t<p>Some <b>unclosed</b></p>\n\n\t<p>and&lt;/i&gt; stray</p>",
+
+	"<div>\n<p>raw\n</div>\n\nnext",
+	"<div>\n\n<p>raw\n\n</p></div>\n\n\t<p>next</p>",
+
+	"<div>\n\nh3. inside\n\n</div>",
+	"<div>\n\n\t<h3>inside</h3>\n\n</div>",
+
+	"|a <b>b|c|",
+	"\t<table>\n\t\t<tr>\n\t\t\t<td>a <b>b</b></td>\n\t\t\t<td>c</td>\n\t\t</tr>\n\t</table>",
+
+	"notextile. <!-- a -- b -->",
+	"<!-- a - - b -->",
+
+	"notextile. <!doctype html><script>if (a < b && c) {}</script>",
+	"<script>if (a &lt; b &amp;&amp; c) {}</script>",
+
+	"notextile. <a href=\"?a=1&b=2\" title='say \"hi\"'>x</a></b>",
+	"<a href=\"?a=1&amp;b=2\" title=\"say &quot;hi&quot;\">x</a>",
+
+	"notextile. a]]</b>> b",
+	"a]]&gt; b",
+
+	"notextile. <b ::=\"::\" :a=1 a:=2 x:y=3>b</b>",
+	"<b x:y=\"3\">b</b>",
+
+	"p(a\x01b). text \x01 ctrl @code\x02@ \"link\x03\":http://x.com/\x04",
+	"\t<p class=\"ab\">text  ctrl <code>code</code> <a href=\"http://x.com/\">link</a></p>",
+
+	"<div>\na \x0b b\n</div>",
+	"<div>\n\n\t<p>a  b</p>\n\n</div>",
+}
+
+func TestXhtml(t *testing.T) {
+	for i := 0; i < len(xhtmlTests); i += 2 {
+		got := convertBytes(t, xhtmlTests[i], &Options{Flavor: FlavorXhtml})
+		if got != xhtmlTests[i+1] {
+			t.Fatalf("\nSrc:%#v\n\nExp:%#v\n\nGot:%#v\n", xhtmlTests[i], xhtmlTests[i+1], got)
+		}
+		checkXml(t, xhtmlTests[i], got)
+	}
+}
+
+func TestXhtmlWellFormed(t *testing.T) {
+	var inputs []string
+	for _, tests := range [][]string{HtmlTests, XhtmlTests, xhtmlTests} {
+		for i := 0; i < len(tests); i += 2 {
+			inputs = append(inputs, tests[i])
+		}
+	}
+	for _, s := range inputs {
+		for _, format := range []Format{FormatTextile, FormatPretty, FormatCompact} {
+			checkXml(t, s, convertBytes(t, s, &Options{Flavor: FlavorXhtml, Format: format}))
+		}
+	}
+}
+
+// FuzzXhtmlWellFormed checks that xhtml output is well-formed xml, starting
+// with the corpus and inputs the fuzzer found problems with
+func FuzzXhtmlWellFormed(f *testing.F) {
+	for _, tests := range [][]string{HtmlTests, XhtmlTests, xhtmlTests} {
+		for i := 0; i < len(tests); i += 2 {
+			f.Add(tests[i])
+		}
+	}
+	f.Add("notextile. <a ::=\"::\">")
+	f.Add("bc. \x00\x1f\ufffe")
+	f.Add("!img\x01.png(alt\x02)!:url\x03 ABC(t\x04) fn\x05[1]\n\nfn1. x")
+	f.Fuzz(func(t *testing.T, s string) {
+		checkXml(t, s, convertBytes(t, s, &Options{Flavor: FlavorXhtml}))
+	})
+}